@@ -0,0 +1,207 @@
+package promtail
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// How often a token file is checked for changes
+	tokenFileCheckInterval = time.Second
+	// Tokens are refreshed a bit before they expire, so requests don't race the expiration
+	tokenRefreshSkew = 30 * time.Second
+)
+
+//
+// Authenticates every outgoing request of exchangers and query client (push, ping, queries and tail),
+// built-in implementations: BasicAuth, BearerToken, BearerTokenFile, BearerTokenFunc and Headers
+//	NOTE: it's called concurrently, so implementations must be safe for concurrent use
+//
+type Authenticator interface {
+	Authenticate(req *http.Request) error
+}
+
+//
+// Implemented by exchangers able to authenticate requests with a custom Authenticator
+//
+type AuthenticatorExchanger interface {
+	SetAuthenticator(authenticator Authenticator)
+}
+
+// Adapts an ordinary function to Authenticator
+type AuthenticatorFunc func(req *http.Request) error
+
+func (fn AuthenticatorFunc) Authenticate(req *http.Request) error {
+	return fn(req)
+}
+
+func BasicAuth(username, password string) Authenticator {
+	return AuthenticatorFunc(func(req *http.Request) error {
+		req.SetBasicAuth(username, password)
+		return nil
+	})
+}
+
+func BearerToken(token string) Authenticator {
+	return AuthenticatorFunc(func(req *http.Request) error {
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	})
+}
+
+// Sets static headers (e.g. API keys of a gateway) to every request
+func Headers(headers map[string]string) Authenticator {
+	headers = copyLabels(headers)
+
+	return AuthenticatorFunc(func(req *http.Request) error {
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		return nil
+	})
+}
+
+// Applies authenticators one by one, e.g. a bearer token along with gateway headers
+func CombineAuthenticators(authenticators ...Authenticator) Authenticator {
+	return AuthenticatorFunc(func(req *http.Request) error {
+		for i := range authenticators {
+			if err := authenticators[i].Authenticate(req); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+//
+// Reads a bearer token from the file (e.g. a projected service account token),
+// the file is re-read once it's changed, so rotated tokens are picked up
+//	NOTE: the last read token is used while the file is unavailable
+//
+func BearerTokenFile(path string) Authenticator {
+	return &fileTokenAuthenticator{
+		path:          path,
+		checkInterval: tokenFileCheckInterval,
+	}
+}
+
+type fileTokenAuthenticator struct {
+	path          string
+	checkInterval time.Duration
+
+	mu        sync.Mutex
+	token     string
+	modTime   time.Time
+	size      int64
+	checkedAt time.Time
+}
+
+func (rcv *fileTokenAuthenticator) Authenticate(req *http.Request) error {
+	token, err := rcv.getToken()
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+func (rcv *fileTokenAuthenticator) getToken() (string, error) {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	if rcv.token != "" && time.Since(rcv.checkedAt) < rcv.checkInterval {
+		return rcv.token, nil
+	}
+	rcv.checkedAt = time.Now()
+
+	info, err := os.Stat(rcv.path)
+	if err != nil {
+		return rcv.cachedToken(fmt.Errorf("failed to check token file: %s", err))
+	}
+
+	if rcv.token != "" && info.ModTime().Equal(rcv.modTime) && info.Size() == rcv.size {
+		return rcv.token, nil
+	}
+
+	content, err := ioutil.ReadFile(rcv.path)
+	if err != nil {
+		return rcv.cachedToken(fmt.Errorf("failed to read token file: %s", err))
+	}
+
+	token := strings.TrimSpace(string(content))
+	if token == "" {
+		return rcv.cachedToken(fmt.Errorf("token file is empty: %s", rcv.path))
+	}
+
+	rcv.token, rcv.modTime, rcv.size = token, info.ModTime(), info.Size()
+
+	return rcv.token, nil
+}
+
+func (rcv *fileTokenAuthenticator) cachedToken(err error) (string, error) {
+	if rcv.token != "" {
+		return rcv.token, nil
+	}
+	return "", err
+}
+
+//
+// Gets a bearer token from the callback and keeps it until it's about to expire
+// (zero expiresAt stands for a token which never expires)
+//	NOTE: the current token is used while the callback fails and the token is not expired yet
+//
+func BearerTokenFunc(refresh func() (token string, expiresAt time.Time, err error)) Authenticator {
+	return &refreshedTokenAuthenticator{refresh: refresh}
+}
+
+type refreshedTokenAuthenticator struct {
+	refresh func() (string, time.Time, error)
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+func (rcv *refreshedTokenAuthenticator) Authenticate(req *http.Request) error {
+	token, err := rcv.getToken()
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+func (rcv *refreshedTokenAuthenticator) getToken() (string, error) {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	now := time.Now()
+
+	if rcv.token != "" && (rcv.expiresAt.IsZero() || now.Before(rcv.expiresAt.Add(-tokenRefreshSkew))) {
+		return rcv.token, nil
+	}
+
+	token, expiresAt, err := rcv.refresh()
+	if err == nil && token == "" {
+		err = errors.New("empty token is received")
+	}
+
+	if err != nil {
+		if rcv.token != "" && now.Before(rcv.expiresAt) {
+			return rcv.token, nil
+		}
+		return "", fmt.Errorf("failed to refresh token: %s", err)
+	}
+
+	rcv.token, rcv.expiresAt = token, expiresAt
+
+	return rcv.token, nil
+}
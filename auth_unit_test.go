@@ -0,0 +1,268 @@
+// +build unit
+
+package promtail
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAuthenticators(t *testing.T) {
+	tests := []struct {
+		name          string
+		authenticator Authenticator
+		wantHeaders   map[string]string
+	}{
+		{
+			name:          "Basic auth",
+			authenticator: BasicAuth("user", "secret"),
+			wantHeaders:   map[string]string{"Authorization": "Basic dXNlcjpzZWNyZXQ="},
+		},
+		{
+			name:          "Static bearer token",
+			authenticator: BearerToken("t0ken"),
+			wantHeaders:   map[string]string{"Authorization": "Bearer t0ken"},
+		},
+		{
+			name:          "Custom headers",
+			authenticator: Headers(map[string]string{"X-Api-Key": "k3y", "X-Gateway": "eu"}),
+			wantHeaders:   map[string]string{"X-Api-Key": "k3y", "X-Gateway": "eu"},
+		},
+		{
+			name:          "Combined authenticators",
+			authenticator: CombineAuthenticators(BearerToken("t0ken"), Headers(map[string]string{"X-Api-Key": "k3y"})),
+			wantHeaders:   map[string]string{"Authorization": "Bearer t0ken", "X-Api-Key": "k3y"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/loki/api/v1/push", nil)
+
+			if err := tt.authenticator.Authenticate(req); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			for name, value := range tt.wantHeaders {
+				if got := req.Header.Get(name); got != value {
+					t.Errorf("header %s = %q, want %q", name, got, value)
+				}
+			}
+		})
+	}
+}
+
+func TestBearerTokenFile(t *testing.T) {
+	directory, err := ioutil.TempDir("", "promtail-token")
+	if err != nil {
+		t.Fatalf("unable to create directory: %s", err)
+	}
+	defer os.RemoveAll(directory)
+
+	path := filepath.Join(directory, "token")
+
+	authenticator := BearerTokenFile(path)
+	authenticator.(*fileTokenAuthenticator).checkInterval = 0
+
+	authorization := func() (string, error) {
+		req := httptest.NewRequest(http.MethodGet, "/ready", nil)
+		err := authenticator.Authenticate(req)
+		return req.Header.Get("Authorization"), err
+	}
+
+	if _, err = authorization(); err == nil {
+		t.Error("expected error on missing token file, but not occurred")
+	}
+
+	if err = ioutil.WriteFile(path, []byte("first\n"), 0600); err != nil {
+		t.Fatalf("unable to write token: %s", err)
+	}
+	if got, err := authorization(); err != nil || got != "Bearer first" {
+		t.Errorf("Authorization = %q, %v, want first token", got, err)
+	}
+
+	// Rotated token is picked up
+	if err = ioutil.WriteFile(path, []byte("second-token\n"), 0600); err != nil {
+		t.Fatalf("unable to write token: %s", err)
+	}
+	if got, err := authorization(); err != nil || got != "Bearer second-token" {
+		t.Errorf("Authorization = %q, %v, want rotated token", got, err)
+	}
+
+	// While the file is being replaced, the last token is used
+	_ = os.Remove(path)
+	if got, err := authorization(); err != nil || got != "Bearer second-token" {
+		t.Errorf("Authorization = %q, %v, want last read token", got, err)
+	}
+}
+
+func TestBearerTokenFunc(t *testing.T) {
+	var (
+		refreshes  = 0
+		refreshErr error
+		expiresIn  = time.Hour
+	)
+
+	authenticator := BearerTokenFunc(func() (string, time.Time, error) {
+		refreshes++
+		if refreshErr != nil {
+			return "", time.Time{}, refreshErr
+		}
+		return "token-" + strconv.Itoa(refreshes), time.Now().Add(expiresIn), nil
+	})
+
+	authorization := func() (string, error) {
+		req := httptest.NewRequest(http.MethodGet, "/ready", nil)
+		err := authenticator.Authenticate(req)
+		return req.Header.Get("Authorization"), err
+	}
+
+	// Token is cached until it's about to expire
+	for i := 0; i < 3; i++ {
+		if got, err := authorization(); err != nil || got != "Bearer token-1" {
+			t.Errorf("Authorization = %q, %v, want cached token", got, err)
+		}
+	}
+	if refreshes != 1 {
+		t.Errorf("token is refreshed %d times, want once", refreshes)
+	}
+
+	// Token, expiring within the refresh skew, is refreshed
+	authenticator.(*refreshedTokenAuthenticator).expiresAt = time.Now().Add(time.Second)
+	if got, err := authorization(); err != nil || got != "Bearer token-2" {
+		t.Errorf("Authorization = %q, %v, want refreshed token", got, err)
+	}
+
+	// Not expired token is used while refresh fails
+	refreshErr = errors.New("identity provider is down")
+	authenticator.(*refreshedTokenAuthenticator).expiresAt = time.Now().Add(time.Second)
+	if got, err := authorization(); err != nil || got != "Bearer token-2" {
+		t.Errorf("Authorization = %q, %v, want not expired token", got, err)
+	}
+
+	// Expired token is not used
+	authenticator.(*refreshedTokenAuthenticator).expiresAt = time.Now().Add(-time.Second)
+	if _, err := authorization(); err == nil {
+		t.Error("expected error on expired token, but not occurred")
+	}
+}
+
+func TestAuthenticator_AppliedToRequests(t *testing.T) {
+	var (
+		authorized   = make(map[string]bool)
+		requestPaths = make(chan string, 10)
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestPaths <- r.URL.Path
+		if r.Header.Get("Authorization") != "Bearer t0ken" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/loki/api/v1/query":
+			_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"streams","result":[]}}`))
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewJSONv1Client(server.URL, nil, WithAuthenticator(BearerToken("t0ken")))
+	if err != nil {
+		t.Fatalf("unexpected error on client initialization: %s", err)
+	}
+	defer client.Close()
+
+	if pong, err := client.Ping(); err != nil || !pong.IsReady {
+		t.Errorf("Ping() = %v, %v, want ready", pong, err)
+	}
+	authorized[<-requestPaths] = true
+
+	queryClient := NewQueryClient(server.URL)
+	queryClient.SetAuthenticator(BearerToken("t0ken"))
+
+	if _, err = queryClient.Query(context.Background(), `{app="a"}`, time.Time{}); err != nil {
+		t.Errorf("Query() error = %s", err)
+	}
+	authorized[<-requestPaths] = true
+
+	if !authorized["/ready"] || !authorized["/loki/api/v1/query"] {
+		t.Errorf("unexpected authorized requests: %v", authorized)
+	}
+}
+
+func TestAuthenticator_EmptyBasicAuth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t0ken" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		options []clientOption
+	}{
+		{
+			name:    "Empty basic auth after authenticator",
+			options: []clientOption{WithAuthenticator(BearerToken("t0ken")), WithBasicAuth("", "")},
+		},
+		{
+			name:    "Empty basic auth before authenticator",
+			options: []clientOption{WithBasicAuth("", ""), WithAuthenticator(BearerToken("t0ken"))},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewJSONv1Client(server.URL, nil, tt.options...)
+			if err != nil {
+				t.Fatalf("unexpected error on client initialization: %s", err)
+			}
+			defer client.Close()
+
+			// Empty credentials must not reset the authenticator
+			if pong, err := client.Ping(); err != nil || !pong.IsReady {
+				t.Errorf("Ping() = %v, %v, want ready", pong, err)
+			}
+		})
+	}
+}
+
+func TestNewClient_AuthMisconfiguration(t *testing.T) {
+	// Custom exchanger which supports neither basic auth nor authenticators
+	exchanger := struct{ StreamsExchanger }{&fakeExchanger{}}
+
+	tests := []struct {
+		name   string
+		option clientOption
+	}{
+		{name: "Basic auth", option: WithBasicAuth("user", "secret")},
+		{name: "Authenticator", option: WithAuthenticator(BearerToken("t0ken"))},
+		{name: "Tenant", option: WithTenantID("team-a")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewClient(exchanger, nil, tt.option); err == nil {
+				t.Error("expected error on unsupported option, but not occurred")
+			}
+		})
+	}
+
+	if _, err := NewJSONv1Client("loki", nil, WithAuthenticator(nil)); err == nil {
+		t.Error("expected error on nil authenticator, but not occurred")
+	}
+}
@@ -1,19 +1,25 @@
 package promtail
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"log"
-	"strings"
+	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
 	defaultSendBatchSize    = 5
 	defaultSendBatchTimeout = 5 * time.Second
-	exchangeQueueSize       = 1024
+	defaultQueueSize        = 1024
 )
 
+// Reserved label to route entries by tenant, see WithTenantLabel()
+const TenantLabel = "__tenant__"
+
 //
 // Creates a Promtail client with a custom Streams exchanger
 //	NOTE: options are applied before client start
@@ -25,7 +31,8 @@ func NewClient(exchanger StreamsExchanger, labels map[string]string, options ...
 
 	c := &promtailClient{
 		exchanger: exchanger,
-		queue:     make(chan packedLogEntry, exchangeQueueSize),
+		queueSize: defaultQueueSize,
+		metrics:   newClientMetrics(),
 
 		errorHandler: func(err error) {
 			if err != nil {
@@ -36,59 +43,457 @@ func NewClient(exchanger StreamsExchanger, labels map[string]string, options ...
 		sendBatchTimeout: defaultSendBatchTimeout,
 		sendBatchSize:    defaultSendBatchSize,
 
+		flushSignal: make(chan chan struct{}),
 		stopSignal:  make(chan struct{}),
 		stopAwaiter: make(chan struct{}),
 	}
 
 	for i := range options {
-		options[i](c)
+		if err := options[i](c); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, ok := c.exchanger.(TenantExchanger); c.tenantLabel != "" && !ok {
+		return nil, errors.New("exchanger doesn't support tenants, entries can't be routed by tenant label")
 	}
 
-	go c.exchange(copyLabels(labels))
+	c.queue = make(chan packedLogEntry, c.queueSize)
+
+	if c.spoolDirectory != "" {
+		var err error
+		if c.spool, err = newSpool(c.spoolDirectory, c.spoolMaxBytes); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.concurrency > 0 {
+		c.workers = newPushWorkers(c.concurrency, c.push)
+	}
+
+	c.defaultLabels = copyLabels(labels)
+	if c.redactor != nil {
+		c.redactor.redactValues(c.defaultLabels)
+	}
+
+	if c.labelValidator != nil {
+		if _, _, err := c.labelValidator.validate(c.defaultLabels, nil, ""); err != nil {
+			return nil, fmt.Errorf("default labels are invalid: %s", err)
+		}
+	}
+
+	go c.exchange(c.defaultLabels)
 
 	return c, nil
 }
 
 func NewJSONv1Client(lokiAddress string, defaultLabels map[string]string, options ...clientOption) (Client, error) {
-	if !(strings.HasPrefix(lokiAddress, "http://") ||
-		strings.HasPrefix(lokiAddress, "https://")) {
-		lokiAddress = "http://" + lokiAddress
-	}
+	return NewClient(NewJSONv1Exchanger(normalizeLokiAddress(lokiAddress)), defaultLabels, options...)
+}
 
-	return NewClient(NewJSONv1Exchanger(lokiAddress), defaultLabels, options...)
+func NewProtoV1Client(lokiAddress string, defaultLabels map[string]string, options ...clientOption) (Client, error) {
+	return NewClient(NewProtoV1Exchanger(normalizeLokiAddress(lokiAddress)), defaultLabels, options...)
 }
 
 func WithSendBatchSize(batchSize uint) clientOption {
-	return func(c *promtailClient) {
+	return func(c *promtailClient) error {
 		c.sendBatchSize = batchSize
+
+		return nil
 	}
 }
 
 func WithSendBatchTimeout(sendTimeout time.Duration) clientOption {
-	return func(c *promtailClient) {
+	return func(c *promtailClient) error {
 		if sendTimeout <= 0 {
-			return
+			return nil
 		}
 
 		c.sendBatchTimeout = sendTimeout
+
+		return nil
+	}
+}
+
+//
+// Retries failed pushes (5xx, 429 and network errors) with exponential backoff and jitter,
+// the error callback is called only when all retries are exhausted
+//	NOTE: Close() doesn't wait for backoffs, a single last attempt is made instead
+//
+func WithRetryPolicy(minBackoff, maxBackoff time.Duration, maxRetries uint) clientOption {
+	return func(c *promtailClient) error {
+		if minBackoff <= 0 {
+			return nil
+		}
+
+		if maxBackoff < minBackoff {
+			maxBackoff = minBackoff
+		}
+
+		c.retryPolicy = retryPolicy{
+			minBackoff: minBackoff,
+			maxBackoff: maxBackoff,
+			maxRetries: maxRetries,
+		}
+
+		return nil
+	}
+}
+
+//
+// Stores every batch in a directory before it's pushed and deletes it once Loki
+// accepts the batch. Batches left from previous runs are replayed on start-up, batches
+// of failed pushes are replayed before the next push or on idle batch timeout, oldest first.
+//	NOTE: when the spool exceeds maxBytes the oldest batches are evicted (0 - no limit)
+//
+func WithSpoolDirectory(directory string, maxBytes int64) clientOption {
+	return func(c *promtailClient) error {
+		c.spoolDirectory = directory
+		c.spoolMaxBytes = maxBytes
+
+		return nil
+	}
+}
+
+//
+// Drops entries below the level before they reach the queue, could be changed
+// later with SetMinLevel (all levels are sent by default)
+//
+func WithMinLevel(level Level) clientOption {
+	return func(c *promtailClient) error {
+		if level > Panic {
+			return fmt.Errorf("unknown log level: %d", level)
+		}
+
+		c.minLevel = uint32(level)
+
+		return nil
+	}
+}
+
+//
+// Keeps the first entries of each message per interval and then every thereafter-th one
+// (0 - none), entries are told apart by level and format, not by formatted line
+// (lines of LogWithTemplate by their template)
+//	NOTE: suppressed entries are reported with a summary entry at the end of each interval
+//
+func WithSampling(interval time.Duration, first, thereafter uint) clientOption {
+	return func(c *promtailClient) error {
+		if interval <= 0 || first == 0 {
+			return errors.New("sampling interval and number of first entries must be positive")
+		}
+
+		if c.sampler == nil {
+			c.sampler = newSampler()
+		}
+
+		c.sampler.interval = interval
+		c.sampler.first = uint64(first)
+		c.sampler.thereafter = uint64(thereafter)
+
+		return nil
+	}
+}
+
+//
+// Limits entries of the level to perSecond rate with bursts up to burst entries,
+// could be set for several levels
+//	NOTE: suppressed entries are reported with a summary entry at the end of each sampling
+//	interval (a minute if sampling isn't set)
+//
+func WithRateLimit(level Level, perSecond float64, burst uint) clientOption {
+	return func(c *promtailClient) error {
+		if perSecond <= 0 || burst == 0 {
+			return errors.New("rate limit and burst must be positive")
+		}
+
+		if c.sampler == nil {
+			c.sampler = newSampler()
+		}
+
+		c.sampler.limiters[level] = newTokenBucket(perSecond, burst)
+
+		return nil
+	}
+}
+
+//
+// Validates labels of entries on logging against Loki's rules, so a single bad label
+// doesn't make Loki reject the whole push: names must match [a-zA-Z_][a-zA-Z0-9_]*,
+// values must be UTF-8 of maxValueLength bytes at most and a stream could have maxNames
+// labels at most (0 - Loki's defaults: 15 names, 2048 bytes)
+//	NOTE: default labels are validated on client creation
+//
+func WithLabelValidation(policy LabelPolicy, maxNames, maxValueLength uint) clientOption {
+	return func(c *promtailClient) error {
+		if policy != SanitizeLabels && policy != DropInvalidLabels && policy != RejectInvalidLabels {
+			return fmt.Errorf("unknown label policy: %d", policy)
+		}
+
+		c.labelValidator = &labelValidator{
+			policy:         policy,
+			maxNames:       defaultMaxLabelNames,
+			maxValueLength: defaultMaxLabelValueLength,
+		}
+
+		if maxNames > 0 {
+			c.labelValidator.maxNames = int(maxNames)
+		}
+		if maxValueLength > 0 {
+			c.labelValidator.maxValueLength = int(maxValueLength)
+		}
+
+		return nil
+	}
+}
+
+//
+// Replaces secrets found by rules in formatted lines, label values and metadata values
+// before they leave the process, built-in rules are used if no rules are given
+//	NOTE: lines are formatted on batching to be redacted, labels are redacted on logging,
+//	before they are validated
+//
+func WithRedaction(mode RedactionMode, rules ...RedactionRule) clientOption {
+	return func(c *promtailClient) error {
+		if len(rules) == 0 {
+			rules = BuiltinRedactionRules()
+		}
+
+		for i := range rules {
+			if rules[i].Pattern == nil {
+				return fmt.Errorf("redaction rule %s has no pattern", rules[i].Name)
+			}
+		}
+
+		c.redactor = &redactor{mode: mode, rules: rules}
+
+		return nil
+	}
+}
+
+//
+// Seals a batch once its estimated size in a push request reaches maxBytes, keep it
+// below Loki's grpc_server_max_recv_msg_size (0 - no limit)
+//	NOTE: messages are formatted on batching to estimate their size
+//
+func WithMaxBatchBytes(maxBytes uint) clientOption {
+	return func(c *promtailClient) error {
+		c.maxBatchBytes = maxBytes
+
+		return nil
+	}
+}
+
+//
+// Truncates or drops log lines longer than maxBytes, keep it below Loki's max_line_size (0 - no limit)
+//	NOTE: the whole line as it's sent is measured: level prefix, message and, with structured
+//	metadata fallback, rendered metadata; only the message is cut to fit the limit, entries
+//	which don't fit even with an empty message are dropped with any policy
+//
+func WithMaxLineBytes(maxBytes uint, policy OversizedLinePolicy) clientOption {
+	return func(c *promtailClient) error {
+		if policy != TruncateLine && policy != DropLine {
+			return fmt.Errorf("unknown oversized line policy: %d", policy)
+		}
+
+		c.maxLineBytes = maxBytes
+		c.oversizedLinePolicy = policy
+
+		return nil
+	}
+}
+
+//
+// Pushes sealed batches from n goroutines, so a slow push doesn't stop batching
+// of new entries (by default batches are pushed one by one right on batching)
+//	NOTE: entries of the same stream are pushed in order by the same goroutine
+//	NOTE: error callback could be called concurrently
+//
+func WithConcurrency(n uint) clientOption {
+	return func(c *promtailClient) error {
+		c.concurrency = n
+
+		return nil
+	}
+}
+
+func WithQueueSize(queueSize uint) clientOption {
+	return func(c *promtailClient) error {
+		if queueSize == 0 {
+			return nil
+		}
+
+		c.queueSize = queueSize
+
+		return nil
+	}
+}
+
+//
+// Defines what happens to a new log entry when the queue is full:
+// Block (default), DropNewest, DropOldest or BlockWithTimeout(timeout)
+//
+func WithOverflowPolicy(policy OverflowPolicy) clientOption {
+	return func(c *promtailClient) error {
+		c.overflowPolicy = policy
+
+		return nil
+	}
+}
+
+//
+// Receives a total number of entries dropped due to queue overflow, on every drop
+//	NOTE: it's called right on the logging goroutine, so keep it fast
+//
+func WithDropCallback(dropHandler func(droppedTotal uint64)) clientOption {
+	return func(c *promtailClient) error {
+		c.dropHandler = dropHandler
+
+		return nil
 	}
 }
 
 func WithErrorCallback(errorHandler func(err error)) clientOption {
-	return func(c *promtailClient) {
+	return func(c *promtailClient) error {
 		c.errorHandler = errorHandler
+
+		return nil
 	}
 }
 
 func WithBasicAuth(username, password string) clientOption {
-	return func(c *promtailClient) {
-		if basicAuthExchanger, ok := c.exchanger.(BasicAuthExchanger); ok {
-			basicAuthExchanger.SetBasicAuth(username, password)
+	return func(c *promtailClient) error {
+		basicAuthExchanger, ok := c.exchanger.(BasicAuthExchanger)
+		if !ok {
+			return errors.New("exchanger doesn't support basic auth")
 		}
+
+		basicAuthExchanger.SetBasicAuth(username, password)
+
+		return nil
 	}
 }
 
-type clientOption func(c *promtailClient)
+//
+// Authenticates every request to Loki, e.g. with BearerToken(), BearerTokenFile(),
+// BearerTokenFunc() or Headers()
+//
+func WithAuthenticator(authenticator Authenticator) clientOption {
+	return func(c *promtailClient) error {
+		if authenticator == nil {
+			return errors.New("authenticator is nil")
+		}
+
+		authenticatorExchanger, ok := c.exchanger.(AuthenticatorExchanger)
+		if !ok {
+			return errors.New("exchanger doesn't support custom authenticators")
+		}
+
+		authenticatorExchanger.SetAuthenticator(authenticator)
+
+		return nil
+	}
+}
+
+//
+// Configures TLS of connections to Loki: CA bundle, client certificate (mTLS),
+// server name, minimum version or disabled verification
+//
+func WithTLSConfig(config TLSConfig) clientOption {
+	return func(c *promtailClient) error {
+		httpExchanger, ok := c.exchanger.(HTTPExchanger)
+		if !ok {
+			return errors.New("exchanger doesn't support TLS configuration")
+		}
+
+		if err := httpExchanger.SetTLSConfig(config); err != nil {
+			return fmt.Errorf("invalid TLS configuration: %s", err)
+		}
+
+		return nil
+	}
+}
+
+//
+// Sends requests to Loki with a custom HTTP client
+//	NOTE: apply WithTLSConfig() after this option, as it's applied to the client's transport
+//
+func WithHTTPClient(httpClient *http.Client) clientOption {
+	return func(c *promtailClient) error {
+		if httpClient == nil {
+			return errors.New("HTTP client is nil")
+		}
+
+		httpExchanger, ok := c.exchanger.(HTTPExchanger)
+		if !ok {
+			return errors.New("exchanger doesn't support custom HTTP clients")
+		}
+
+		httpExchanger.SetHTTPClient(httpClient)
+
+		return nil
+	}
+}
+
+// Sends requests to Loki via a custom round tripper (e.g. an instrumented one)
+func WithRoundTripper(roundTripper http.RoundTripper) clientOption {
+	return func(c *promtailClient) error {
+		if roundTripper == nil {
+			return errors.New("round tripper is nil")
+		}
+
+		return WithHTTPClient(&http.Client{Transport: roundTripper})(c)
+	}
+}
+
+//
+// Pushes logs on behalf of the tenant of a multi-tenant Loki (X-Scope-OrgID header)
+//
+func WithTenantID(tenantID string) clientOption {
+	return func(c *promtailClient) error {
+		tenantExchanger, ok := c.exchanger.(TenantExchanger)
+		if !ok {
+			return errors.New("exchanger doesn't support tenants")
+		}
+
+		tenantExchanger.SetTenantID(tenantID)
+
+		return nil
+	}
+}
+
+//
+// Routes entries to the tenant named by the label (e.g. TenantLabel) of LogfWithLabels,
+// each tenant's batch is pushed separately and the label itself is not sent
+//	NOTE: entries without the label are pushed on behalf of WithTenantID's tenant
+//
+func WithTenantLabel(labelName string) clientOption {
+	return func(c *promtailClient) error {
+		c.tenantLabel = labelName
+
+		return nil
+	}
+}
+
+//
+// Renders structured metadata into log lines as logfmt, for Loki servers
+// which don't support structured metadata yet
+//
+func WithStructuredMetadataFallback() clientOption {
+	return func(c *promtailClient) error {
+		fallbackExchanger, ok := c.exchanger.(StructuredMetadataFallbackExchanger)
+		if !ok {
+			return errors.New("exchanger doesn't support structured metadata fallback")
+		}
+
+		fallbackExchanger.SetStructuredMetadataFallback(true)
+		c.metadataFallback = true
+
+		return nil
+	}
+}
+
+type clientOption func(c *promtailClient) error
 
 type packedLogEntry struct {
 	level    Level
@@ -97,13 +502,42 @@ type packedLogEntry struct {
 }
 
 type promtailClient struct {
+	droppedEntries uint64 // Accessed atomically, kept first for 64-bit alignment
+	minLevel       uint32 // Accessed atomically
+
 	errorHandler func(error)
+	dropHandler  func(droppedTotal uint64)
 
 	sendBatchSize    uint
 	sendBatchTimeout time.Duration
+	retryPolicy      retryPolicy
 
-	queue     chan packedLogEntry
-	exchanger StreamsExchanger
+	spool          *spool
+	spoolDirectory string
+	spoolMaxBytes  int64
+	replayMu       sync.Mutex
+
+	concurrency uint
+	workers     *pushWorkers
+
+	defaultLabels  map[string]string
+	labelValidator *labelValidator
+	sampler        *sampler
+	redactor       *redactor
+
+	maxBatchBytes       uint
+	maxLineBytes        uint
+	oversizedLinePolicy OversizedLinePolicy
+	metadataFallback    bool // Metadata is rendered into lines by the exchanger, so it's counted by limits
+
+	queue          chan packedLogEntry
+	queueSize      uint
+	overflowPolicy OverflowPolicy
+	exchanger      StreamsExchanger
+	metrics        *clientMetrics
+	tenantLabel    string
+
+	flushSignal chan chan struct{}
 
 	isStopped   bool
 	stopSignal  chan struct{}
@@ -120,20 +554,80 @@ func (rcv *promtailClient) Logf(level Level, format string, args ...interface{})
 }
 
 func (rcv *promtailClient) LogfWithLabels(level Level, labels map[string]string, format string, args ...interface{}) {
+	rcv.logfWithTemplate(level, labels, format, format, args...)
+}
+
+//
+// Logs a prepared line, sampling it by the template it's rendered from (e.g. a message
+// without attributes), so lines with different attributes count as one message
+//
+func (rcv *promtailClient) LogWithTemplate(level Level, labels map[string]string, template, line string) {
+	rcv.logfWithTemplate(level, labels, template, "%s", line)
+}
+
+func (rcv *promtailClient) logfWithTemplate(
+	level Level, labels map[string]string, template, format string, args ...interface{},
+) {
+	if !rcv.isLevelEnabled(level) || !rcv.isSampled(level, template) {
+		return
+	}
+
 	if rcv.isStopped { // Escape from endless lock
 		log.Println("promtail client is stopped, no log entries will be sent!")
 		return
 	}
 
-	rcv.queue <- packedLogEntry{
-		labels: copyLabels(labels),
+	entryLabels := copyLabels(labels)
+	rcv.redactLabels(entryLabels)
+
+	if !rcv.validateLabels(entryLabels) {
+		return
+	}
+
+	rcv.enqueue(packedLogEntry{
+		labels: entryLabels,
 		level:  level,
 		logEntry: &LogEntry{
 			Timestamp: time.Now(),
 			Format:    format,
 			Args:      args,
 		},
+	})
+}
+
+//
+// Logs a message with key/value pairs attached as structured metadata,
+// which (unlike labels) is not indexed, so it's fine to have high cardinality values there
+//	NOTE: keys and values are formatted with fmt.Sprint, a missing value is replaced with "(MISSING)"
+//
+func (rcv *promtailClient) LogKV(level Level, msg string, keyvals ...interface{}) {
+	if !rcv.isLevelEnabled(level) || !rcv.isSampled(level, msg) {
+		return
 	}
+
+	if rcv.isStopped { // Escape from endless lock
+		log.Println("promtail client is stopped, no log entries will be sent!")
+		return
+	}
+
+	metadata := make(map[string]string, (len(keyvals)+1)/2)
+	for i := 0; i < len(keyvals); i += 2 {
+		value := "(MISSING)"
+		if i+1 < len(keyvals) {
+			value = fmt.Sprint(keyvals[i+1])
+		}
+		metadata[fmt.Sprint(keyvals[i])] = value
+	}
+
+	rcv.enqueue(packedLogEntry{
+		level: level,
+		logEntry: &LogEntry{
+			Timestamp: time.Now(),
+			Format:    "%s",
+			Args:      []interface{}{msg},
+			Metadata:  metadata,
+		},
+	})
 }
 
 func (rcv *promtailClient) Debugf(format string, args ...interface{}) {
@@ -160,6 +654,55 @@ func (rcv *promtailClient) Panicf(format string, args ...interface{}) {
 	rcv.Logf(Panic, format, args...)
 }
 
+//
+// Logs with labels attached to ctx via ContextWithLabels()
+//
+func (rcv *promtailClient) LogfCtx(ctx context.Context, level Level, format string, args ...interface{}) {
+	rcv.LogfWithLabels(level, labelsFromContext(ctx), format, args...)
+}
+
+func (rcv *promtailClient) DebugfCtx(ctx context.Context, format string, args ...interface{}) {
+	rcv.LogfCtx(ctx, Debug, format, args...)
+}
+
+func (rcv *promtailClient) InfofCtx(ctx context.Context, format string, args ...interface{}) {
+	rcv.LogfCtx(ctx, Info, format, args...)
+}
+
+func (rcv *promtailClient) WarnfCtx(ctx context.Context, format string, args ...interface{}) {
+	rcv.LogfCtx(ctx, Warn, format, args...)
+}
+
+func (rcv *promtailClient) ErrorfCtx(ctx context.Context, format string, args ...interface{}) {
+	rcv.LogfCtx(ctx, Error, format, args...)
+}
+
+func (rcv *promtailClient) FatalfCtx(ctx context.Context, format string, args ...interface{}) {
+	rcv.LogfCtx(ctx, Fatal, format, args...)
+}
+
+func (rcv *promtailClient) PanicfCtx(ctx context.Context, format string, args ...interface{}) {
+	rcv.LogfCtx(ctx, Panic, format, args...)
+}
+
+//
+// Pushes entries logged so far without waiting for the batch to be full,
+// returns once the push is done
+//
+func (rcv *promtailClient) Flush() {
+	if rcv.isStopped {
+		return
+	}
+
+	flushAwaiter := make(chan struct{})
+
+	select {
+	case rcv.flushSignal <- flushAwaiter:
+		<-flushAwaiter
+	case <-rcv.stopSignal:
+	}
+}
+
 func (rcv *promtailClient) Close() {
 	rcv.stopOnce.Do(func() {
 		rcv.isStopped = true  // Deny new incoming logs
@@ -170,13 +713,23 @@ func (rcv *promtailClient) Close() {
 
 func (rcv *promtailClient) exchange(defaultLabels map[string]string) {
 	var (
-		err error
-
 		incomeLogEntry packedLogEntry
-		batch          = newBatch(defaultLabels)
+		batch          = newBatch(defaultLabels, rcv.tenantLabel)
 		batchTimer     = time.NewTimer(rcv.sendBatchTimeout)
 	)
 
+	if rcv.spool != nil {
+		rcv.replaySpool()
+	}
+
+	var samplingSummaryTick <-chan time.Time
+	if rcv.sampler != nil {
+		samplingSummaryTicker := time.NewTicker(rcv.sampler.interval)
+		defer samplingSummaryTicker.Stop()
+
+		samplingSummaryTick = samplingSummaryTicker.C
+	}
+
 exchangeLoop:
 	for {
 
@@ -185,15 +738,7 @@ exchangeLoop:
 		// On new log message
 		case incomeLogEntry = <-rcv.queue:
 			{
-				batch.add(incomeLogEntry)
-
-				if batch.countEntries() >= rcv.sendBatchSize {
-					err = rcv.exchanger.Push(batch.getStreams())
-					if err != nil {
-						rcv.errorHandler(err)
-					}
-
-					batch.reset()
+				if rcv.addToBatch(batch, incomeLogEntry) {
 					batchTimer.Reset(rcv.sendBatchTimeout)
 				}
 			}
@@ -202,26 +747,58 @@ exchangeLoop:
 		case <-batchTimer.C:
 			{
 				if batch.countEntries() > 0 {
-					err = rcv.exchanger.Push(batch.getStreams())
-					if err != nil {
-						rcv.errorHandler(err)
-					}
+					rcv.dispatch(batch.getStreams())
+					batch.reset()
+				} else if rcv.spool != nil && rcv.spool.isPending() {
+					rcv.replaySpool()
+				}
 
+				batchTimer.Reset(rcv.sendBatchTimeout)
+			}
+
+		// On sampling interval end
+		case <-samplingSummaryTick:
+			{
+				rcv.addSamplingSummaries(batch)
+			}
+
+		// On flush request
+		case flushAwaiter := <-rcv.flushSignal:
+			{
+				rcv.drainQueue(batch)
+
+				if batch.countEntries() > 0 {
+					rcv.dispatch(batch.getStreams())
 					batch.reset()
 				}
 
+				if rcv.workers != nil {
+					rcv.workers.await()
+				}
+
 				batchTimer.Reset(rcv.sendBatchTimeout)
+				close(flushAwaiter)
 			}
 
 		// On client stop
 		case <-rcv.stopSignal:
 			{
 				batchTimer.Stop()
+
+				// Entries queued before the stop are still expected to be sent
+				rcv.drainQueue(batch)
+
+				if rcv.sampler != nil {
+					rcv.addSamplingSummaries(batch)
+				}
+
 				if batch.countEntries() > 0 {
-					err = rcv.exchanger.Push(batch.getStreams())
-					if err != nil {
-						rcv.errorHandler(err)
-					}
+					rcv.dispatch(batch.getStreams())
+				}
+
+				// In-flight pushes are awaited as well
+				if rcv.workers != nil {
+					rcv.workers.stop()
 				}
 
 				rcv.stopAwaiter <- struct{}{}
@@ -232,14 +809,266 @@ exchangeLoop:
 	}
 }
 
+//
+// Moves entries already waiting in the queue into the batch, pushing it each time it's full
+//
+func (rcv *promtailClient) drainQueue(batch *logStreamBatch) {
+	for {
+		select {
+		case incomeLogEntry := <-rcv.queue:
+			rcv.addToBatch(batch, incomeLogEntry)
+		default:
+			return
+		}
+	}
+}
+
+//
+// Adds the entry to the batch, sealing the batch once it's full by entries or bytes,
+// reports whether the batch has been sealed
+//
+func (rcv *promtailClient) addToBatch(batch *logStreamBatch, entry packedLogEntry) bool {
+	if !rcv.prepareEntry(entry) {
+		return false
+	}
+
+	// The entry would overflow the batch, so it goes to the next one
+	if rcv.maxBatchBytes > 0 && batch.countEntries() > 0 &&
+		batch.countBytes()+estimateEntryBytes(entry.logEntry) > rcv.maxBatchBytes {
+		atomic.AddUint64(&rcv.metrics.batchesSizeLimited, 1)
+		rcv.dispatch(batch.getStreams())
+		batch.reset()
+	}
+
+	batch.add(entry)
+
+	if batch.countEntries() >= rcv.sendBatchSize ||
+		(rcv.maxBatchBytes > 0 && batch.countBytes() >= rcv.maxBatchBytes) {
+		if batch.countEntries() < rcv.sendBatchSize {
+			atomic.AddUint64(&rcv.metrics.batchesSizeLimited, 1)
+		}
+
+		rcv.dispatch(batch.getStreams())
+		batch.reset()
+
+		return true
+	}
+
+	return false
+}
+
+//
+// Formats the entry's message once if it's needed on batching (redaction, size limits),
+// reports whether the entry should be batched
+//
+func (rcv *promtailClient) prepareEntry(entry packedLogEntry) bool {
+	if rcv.redactor == nil && rcv.maxLineBytes == 0 && rcv.maxBatchBytes == 0 {
+		return true
+	}
+
+	line := fmt.Sprintf(entry.logEntry.Format, entry.logEntry.Args...)
+
+	if rcv.redactor != nil {
+		line = rcv.redactor.redact(line)
+		rcv.redactor.redactValues(entry.logEntry.Metadata)
+	}
+
+	line, ok := rcv.limitLine(entry.level, line, entry.logEntry.Metadata)
+	if !ok {
+		return false
+	}
+
+	entry.logEntry.Format, entry.logEntry.Args = "%s", []interface{}{line}
+
+	return true
+}
+
+//
+// Pushes streams right away or hands them over to push workers, if enabled
+//
+func (rcv *promtailClient) dispatch(streams []*LogStream) {
+	if rcv.workers != nil {
+		rcv.workers.dispatch(streams)
+		return
+	}
+
+	rcv.push(streams)
+}
+
+//
+// Pushes streams tenant by tenant, so a failure of one tenant doesn't make others retried
+//
+func (rcv *promtailClient) push(streams []*LogStream) {
+	for _, tenantStreams := range groupStreamsByTenant(streams) {
+		// Batch keeps empty streams of every level, they're not worth a push on their own
+		if countEntries(tenantStreams) > 0 {
+			rcv.pushTenant(tenantStreams)
+		}
+	}
+}
+
+//
+// Pushes streams (with the spool in front, if enabled), reports an error only
+// if the push has finally failed
+//
+func (rcv *promtailClient) pushTenant(streams []*LogStream) {
+	var segment string
+
+	if rcv.spool != nil {
+		var (
+			evicted []string
+			err     error
+		)
+
+		segment, evicted, err = rcv.spool.write(streams)
+		for i := range evicted {
+			rcv.errorHandler(fmt.Errorf("spool size limit is exceeded, undelivered batch is evicted: %s", evicted[i]))
+		}
+		if err != nil {
+			rcv.errorHandler(err)
+		}
+	}
+
+	// Older batches are still waiting in the spool, so the batch goes after them
+	if segment != "" && rcv.spool.isPending() {
+		rcv.spool.release(segment)
+		rcv.replaySpool()
+		return
+	}
+
+	err := rcv.deliver(streams)
+	rcv.metrics.observeBatch(streams, err)
+
+	// Batches which could be accepted later stay in the spool till the next replay
+	if segment != "" {
+		if err != nil && isRetryableError(err) {
+			rcv.spool.release(segment)
+		} else if removeErr := rcv.spool.remove(segment); removeErr != nil {
+			rcv.errorHandler(removeErr)
+		}
+	}
+
+	if err != nil {
+		rcv.errorHandler(err)
+	}
+}
+
+//
+// Pushes streams keeping them between retry attempts
+//
+func (rcv *promtailClient) deliver(streams []*LogStream) error {
+	err := rcv.pushOnce(streams)
+
+	for attempt := uint(0); err != nil && attempt < rcv.retryPolicy.maxRetries && isRetryableError(err); attempt++ {
+		if !rcv.awaitBackoff(rcv.retryPolicy.backoff(attempt)) {
+			// Client is stopping, so the last attempt is made right away, undelivered batch
+			// is left to the spool (if enabled)
+			return rcv.pushOnce(streams)
+		}
+
+		err = rcv.pushOnce(streams)
+	}
+
+	return err
+}
+
+//
+// Waits for the backoff, reports false if the client is stopped meanwhile
+//
+func (rcv *promtailClient) awaitBackoff(backoff time.Duration) bool {
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-rcv.stopSignal:
+		return false
+	}
+}
+
+func (rcv *promtailClient) pushOnce(streams []*LogStream) error {
+	startedAt := time.Now()
+	err := rcv.exchanger.Push(streams)
+	rcv.metrics.observePush(time.Since(startedAt), err)
+
+	return err
+}
+
+//
+// Pushes batches left in the spool by previous runs or failed pushes oldest first,
+// stops on the first failure which is worth to be retried later
+//	NOTE: batches keep waiting in the spool till the next replay, which happens on
+//	the next push or idle batch timeout; batches being pushed by other workers are skipped
+//
+func (rcv *promtailClient) replaySpool() {
+	rcv.replayMu.Lock()
+	defer rcv.replayMu.Unlock()
+
+	// Batches of failed pushes may be released meanwhile, so the spool is listed till it's empty
+	for {
+		segments, err := rcv.spool.pendingSegments()
+		if err != nil {
+			rcv.errorHandler(err)
+			return
+		}
+
+		if len(segments) == 0 {
+			return
+		}
+
+		for i := range segments {
+			if !rcv.replaySegment(segments[i]) {
+				return
+			}
+		}
+	}
+}
+
+//
+// Pushes the segment, removing it unless the push is worth to be retried later,
+// reports whether the replay should go on
+//
+func (rcv *promtailClient) replaySegment(segment string) bool {
+	streams, err := rcv.spool.read(segment)
+	if err != nil {
+		rcv.errorHandler(fmt.Errorf("failed to replay spool segment %s, segment is removed: %s", segment, err))
+	} else {
+		err = rcv.deliver(streams)
+		rcv.metrics.observeBatch(streams, err)
+
+		if err != nil {
+			rcv.errorHandler(fmt.Errorf("failed to replay spool segment %s: %s", segment, err))
+
+			if isRetryableError(err) {
+				rcv.spool.release(segment)
+				return false
+			}
+		}
+	}
+
+	// A segment which can't be removed would be replayed again and again
+	if err = rcv.spool.remove(segment); err != nil {
+		rcv.errorHandler(err)
+		return false
+	}
+
+	return true
+}
+
 type logStreamBatch struct {
 	size             uint
+	bytes            uint
 	predefinedLabels map[string]string
+	tenantLabel      string
 	streams          []*LogStream
 }
 
-func newBatch(predefinedLabels map[string]string) *logStreamBatch {
-	rcv := &logStreamBatch{predefinedLabels: copyLabels(predefinedLabels)}
+func newBatch(predefinedLabels map[string]string, tenantLabel string) *logStreamBatch {
+	rcv := &logStreamBatch{
+		predefinedLabels: copyLabels(predefinedLabels),
+		tenantLabel:      tenantLabel,
+	}
 	rcv.reset()
 	return rcv
 }
@@ -247,22 +1076,37 @@ func newBatch(predefinedLabels map[string]string) *logStreamBatch {
 func (rcv *logStreamBatch) add(entry packedLogEntry) {
 	rcv.size += 1
 
+	var tenantID string
+	if rcv.tenantLabel != "" {
+		// Labels are already copied on logging, so it's safe to modify them
+		tenantID = entry.labels[rcv.tenantLabel]
+		delete(entry.labels, rcv.tenantLabel)
+	}
+
 	cachedIndex := rcv._getLevelIndex(entry.level)
 
-	// For both use cases (custom labels and unknown log level we would add entry in a separate stream)
-	if len(entry.labels) > 0 || cachedIndex < 0 {
+	// For use cases (custom labels, tenant and unknown log level we would add entry in a separate stream)
+	if len(entry.labels) > 0 || tenantID != "" || cachedIndex < 0 {
 		dedicatedStream := newLeveledStream(entry.level, rcv.predefinedLabels, entry.labels)
 		dedicatedStream.Entries = []*LogEntry{entry.logEntry}
+		dedicatedStream.TenantID = tenantID
 		rcv.streams = append(rcv.streams, dedicatedStream)
+		rcv.bytes += estimateLabelsBytes(dedicatedStream.Labels)
 	} else {
 		// Or add to a cached stream :)
+		if len(rcv.streams[cachedIndex].Entries) == 0 {
+			rcv.bytes += estimateLabelsBytes(rcv.streams[cachedIndex].Labels)
+		}
 		rcv.streams[cachedIndex].Entries = append(rcv.streams[cachedIndex].Entries,
 			entry.logEntry)
 	}
+
+	rcv.bytes += estimateEntryBytes(entry.logEntry)
 }
 
 func (rcv *logStreamBatch) reset() {
 	rcv.size = 0
+	rcv.bytes = 0
 	rcv.streams = make([]*LogStream, len(rcv._getCachedLevels()))
 	rcv.streams[rcv._getLevelIndex(Debug)] = newLeveledStream(Debug, rcv.predefinedLabels)
 	rcv.streams[rcv._getLevelIndex(Info)] = newLeveledStream(Info, rcv.predefinedLabels)
@@ -280,6 +1124,11 @@ func (rcv *logStreamBatch) countEntries() uint {
 	return rcv.size
 }
 
+// Estimated size of the batch in a push request
+func (rcv *logStreamBatch) countBytes() uint {
+	return rcv.bytes
+}
+
 func (rcv *logStreamBatch) _getLevelIndex(level Level) int {
 	switch level {
 	case Debug:
@@ -4,6 +4,10 @@ package promtail
 
 import (
 	"math/rand"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -100,7 +104,7 @@ func TestPromtailClient_Batch_Scenario(t *testing.T) {
 		}
 	)
 
-	batch := newBatch(predefinedlabels)
+	batch := newBatch(predefinedlabels, "")
 
 	//
 	// Verify initialization
@@ -168,3 +172,210 @@ func TestPromtailClient_Batch_Scenario(t *testing.T) {
 			len(batch._getCachedLevels()), len(batch.getStreams()))
 	}
 }
+
+func TestPromtailClient_Flush(t *testing.T) {
+	exchanger := &fakeExchanger{}
+
+	client, err := NewClient(exchanger, nil,
+		WithSendBatchSize(100),
+		WithSendBatchTimeout(time.Hour),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error on client initialization: %s", err)
+	}
+	defer client.Close()
+
+	for i := 0; i < 3; i++ {
+		client.Infof("entry #%d", i)
+	}
+
+	client.Flush()
+
+	if got := exchanger.countPushedEntries(); got != 3 {
+		t.Errorf("unexpected number of flushed entries, got: %d, want: %d", got, 3)
+	}
+
+	// Nothing to flush
+	client.Flush()
+
+	if got := exchanger.countAttempts(); got != 1 {
+		t.Errorf("empty batch must not be pushed, pushes: %d", got)
+	}
+}
+
+func TestPromtailClient_LogKV(t *testing.T) {
+	exchanger := &fakeExchanger{}
+
+	client, err := NewClient(exchanger, nil)
+	if err != nil {
+		t.Fatalf("unexpected error on client initialization: %s", err)
+	}
+
+	client.LogKV(Info, "user logged in", "userId", 42, "sessionId")
+	client.Close()
+
+	var entries []*LogEntry
+	for _, stream := range exchanger.pushed[0] {
+		entries = append(entries, stream.Entries...)
+	}
+
+	if len(entries) != 1 {
+		t.Fatalf("unexpected number of entries: %d", len(entries))
+	}
+
+	want := map[string]string{"userId": "42", "sessionId": "(MISSING)"}
+	if !reflect.DeepEqual(entries[0].Metadata, want) {
+		t.Errorf("unexpected metadata, got: %v, want: %v", entries[0].Metadata, want)
+	}
+}
+
+func TestPromtailClient_TenantLabel(t *testing.T) {
+	exchanger := &fakeExchanger{}
+
+	client, err := NewClient(exchanger, map[string]string{"app": "api"},
+		WithSendBatchSize(100),
+		WithSendBatchTimeout(time.Hour),
+		WithTenantID("default"),
+		WithTenantLabel(TenantLabel),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error on client initialization: %s", err)
+	}
+	defer client.Close()
+
+	if exchanger.tenantID != "default" {
+		t.Errorf("static tenant is not set to the exchanger, got: %q", exchanger.tenantID)
+	}
+
+	client.LogfWithLabels(Info, map[string]string{TenantLabel: "team-a"}, "first of team-a")
+	client.Infof("no tenant")
+	client.LogfWithLabels(Warn, map[string]string{TenantLabel: "team-b", "host": "h1"}, "first of team-b")
+	client.LogfWithLabels(Error, map[string]string{TenantLabel: "team-a"}, "second of team-a")
+	client.Flush()
+
+	exchanger.mu.Lock()
+	defer exchanger.mu.Unlock()
+
+	// Every tenant is pushed separately
+	got := make(map[string]int)
+	for _, streams := range exchanger.pushed {
+		tenantID := streams[0].TenantID
+
+		for _, stream := range streams {
+			if stream.TenantID != tenantID {
+				t.Errorf("streams of tenants %q and %q are pushed together", tenantID, stream.TenantID)
+			}
+			if _, ok := stream.Labels[TenantLabel]; ok {
+				t.Errorf("tenant label is not stripped: %v", stream.Labels)
+			}
+			got[tenantID] += len(stream.Entries)
+		}
+	}
+
+	if want := map[string]int{"team-a": 2, "team-b": 1, "": 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected entries of tenants:\n got  = %v\n want = %v", got, want)
+	}
+
+	// Exchangers which can't push on behalf of a tenant are not able to route entries
+	singleTenantExchanger := struct{ StreamsExchanger }{&fakeExchanger{}}
+
+	if _, err = NewClient(singleTenantExchanger, nil, WithTenantLabel(TenantLabel)); err == nil {
+		t.Error("expected error on tenant routing without tenant support, but not occurred")
+	}
+}
+
+type slowExchanger struct {
+	fakeExchanger
+	delay       time.Duration
+	active      int32
+	maxParallel int32
+}
+
+func (rcv *slowExchanger) Push(streams []*LogStream) error {
+	active := atomic.AddInt32(&rcv.active, 1)
+	defer atomic.AddInt32(&rcv.active, -1)
+
+	for {
+		maxParallel := atomic.LoadInt32(&rcv.maxParallel)
+		if active <= maxParallel || atomic.CompareAndSwapInt32(&rcv.maxParallel, maxParallel, active) {
+			break
+		}
+	}
+
+	time.Sleep(rcv.delay)
+
+	return rcv.fakeExchanger.Push(streams)
+}
+
+func TestPromtailClient_Concurrency(t *testing.T) {
+	const (
+		streamsNumber    = 8
+		entriesPerStream = 10
+	)
+
+	exchanger := &slowExchanger{delay: 10 * time.Millisecond}
+
+	client, err := NewClient(exchanger, nil,
+		WithSendBatchSize(3),
+		WithSendBatchTimeout(time.Hour),
+		WithConcurrency(4),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error on client initialization: %s", err)
+	}
+
+	for i := 0; i < entriesPerStream; i++ {
+		for j := 0; j < streamsNumber; j++ {
+			client.LogfWithLabels(Info, map[string]string{"stream": strconv.Itoa(j)}, "%d", i)
+		}
+	}
+
+	// In-flight pushes must be awaited
+	client.Close()
+
+	if got := exchanger.countPushedEntries(); got != streamsNumber*entriesPerStream {
+		t.Fatalf("unexpected number of pushed entries, got: %d, want: %d", got, streamsNumber*entriesPerStream)
+	}
+
+	if maxParallel := atomic.LoadInt32(&exchanger.maxParallel); maxParallel < 2 {
+		t.Errorf("batches must be pushed concurrently, max parallel pushes: %d", maxParallel)
+	}
+
+	got := map[string][]int{}
+	for _, streams := range exchanger.pushed {
+		for _, stream := range streams {
+			for _, entry := range stream.Entries {
+				got[stream.Labels["stream"]] = append(got[stream.Labels["stream"]], entry.Args[0].(int))
+			}
+		}
+	}
+
+	for stream, sequence := range got {
+		if !sort.IntsAreSorted(sequence) {
+			t.Errorf("entries of stream %s are pushed out of order: %v", stream, sequence)
+		}
+	}
+}
+
+func TestPromtailClient_Concurrency_Flush(t *testing.T) {
+	exchanger := &slowExchanger{delay: 50 * time.Millisecond}
+
+	client, err := NewClient(exchanger, nil,
+		WithSendBatchSize(100),
+		WithSendBatchTimeout(time.Hour),
+		WithConcurrency(2),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error on client initialization: %s", err)
+	}
+	defer client.Close()
+
+	client.Infof("first")
+	client.Errorf("second")
+
+	client.Flush()
+
+	if got := exchanger.countPushedEntries(); got != 2 {
+		t.Errorf("flush must wait for in-flight pushes, pushed entries: %d", got)
+	}
+}
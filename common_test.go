@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"sync"
 	"testing"
 	"time"
 )
@@ -112,3 +113,69 @@ func getEnvIntWithFallback(varName string, fallbackValue int) (int, error) {
 	}
 	return fallbackValue, nil
 }
+
+//
+// Streams exchanger that records pushed streams instead of sending them,
+// pushes fail with errors from `failures` until it's exhausted (nil lets a push through),
+// every push takes `latency`
+//
+type fakeExchanger struct {
+	mu       sync.Mutex
+	latency  time.Duration
+	failures []error
+	attempts int
+	pushed   [][]*LogStream
+	tenantID string
+	fallback bool
+}
+
+func (rcv *fakeExchanger) Push(streams []*LogStream) error {
+	time.Sleep(rcv.latency)
+
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	rcv.attempts++
+
+	if len(rcv.failures) > 0 {
+		err := rcv.failures[0]
+		rcv.failures = rcv.failures[1:]
+		if err != nil {
+			return err
+		}
+	}
+
+	rcv.pushed = append(rcv.pushed, streams)
+	return nil
+}
+
+func (rcv *fakeExchanger) SetTenantID(tenantID string) {
+	rcv.tenantID = tenantID
+}
+
+func (rcv *fakeExchanger) SetStructuredMetadataFallback(enabled bool) {
+	rcv.fallback = enabled
+}
+
+func (rcv *fakeExchanger) Ping() (*PongResponse, error) {
+	return &PongResponse{IsReady: true}, nil
+}
+
+func (rcv *fakeExchanger) countAttempts() int {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	return rcv.attempts
+}
+
+func (rcv *fakeExchanger) countPushedEntries() int {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	count := 0
+	for i := range rcv.pushed {
+		for j := range rcv.pushed[i] {
+			count += len(rcv.pushed[i][j].Entries)
+		}
+	}
+	return count
+}
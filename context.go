@@ -0,0 +1,27 @@
+package promtail
+
+import "context"
+
+type contextLabelsKey struct{}
+
+//
+// Returns a copy of ctx carrying labels, merged with labels attached to ctx before
+// (on conflict the latest label wins, the same way as for client's default labels)
+//
+func ContextWithLabels(ctx context.Context, labels map[string]string) context.Context {
+	return context.WithValue(ctx, contextLabelsKey{}, copyAndMergeLabels(labelsFromContext(ctx), labels))
+}
+
+// Returns a copy of labels attached to ctx
+func LabelsFromContext(ctx context.Context) map[string]string {
+	return copyLabels(labelsFromContext(ctx))
+}
+
+func labelsFromContext(ctx context.Context) map[string]string {
+	if ctx == nil {
+		return nil
+	}
+
+	labels, _ := ctx.Value(contextLabelsKey{}).(map[string]string)
+	return labels
+}
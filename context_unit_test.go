@@ -0,0 +1,79 @@
+// +build unit
+
+package promtail
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestContextWithLabels(t *testing.T) {
+	var (
+		requestCtx = ContextWithLabels(context.Background(), map[string]string{
+			"requestId": "req-1",
+			"tenantId":  "tenant-a",
+		})
+		handlerCtx = ContextWithLabels(requestCtx, map[string]string{
+			"tenantId": "tenant-b",
+			"traceId":  "trace-1",
+		})
+	)
+
+	want := map[string]string{
+		"requestId": "req-1",
+		"tenantId":  "tenant-b",
+		"traceId":   "trace-1",
+	}
+	if got := LabelsFromContext(handlerCtx); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected labels in nested context, got: %v, want: %v", got, want)
+	}
+
+	// Parent context stays untouched
+	if got := LabelsFromContext(requestCtx); got["tenantId"] != "tenant-a" || got["traceId"] != "" {
+		t.Errorf("parent context labels are modified: %v", got)
+	}
+
+	if got := LabelsFromContext(context.Background()); len(got) != 0 {
+		t.Errorf("unexpected labels in empty context: %v", got)
+	}
+}
+
+func TestPromtailClient_LogfCtx(t *testing.T) {
+	exchanger := &fakeExchanger{}
+
+	client, err := NewClient(exchanger, map[string]string{
+		"instanceId": "instance-a1",
+		"tenantId":   "default",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on client initialization: %s", err)
+	}
+
+	ctx := ContextWithLabels(context.Background(), map[string]string{
+		"tenantId":          "tenant-a",
+		logLevelForcedLabel: "forged",
+	})
+
+	client.WarnfCtx(ctx, "something happened to %s", "tenant")
+	client.Close()
+
+	if len(exchanger.pushed) != 1 {
+		t.Fatalf("unexpected number of pushes: %d", len(exchanger.pushed))
+	}
+
+	want := map[string]string{
+		"instanceId":        "instance-a1",
+		"tenantId":          "tenant-a",
+		logLevelForcedLabel: Warn.String(),
+	}
+
+	for _, stream := range exchanger.pushed[0] {
+		if len(stream.Entries) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(stream.Labels, want) {
+			t.Errorf("unexpected stream labels, got: %v, want: %v", stream.Labels, want)
+		}
+	}
+}
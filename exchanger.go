@@ -7,24 +7,35 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"sort"
 	"strconv"
 	"time"
+
+	"github.com/ic2hrmk/promtail/logfmt"
 )
 
 type LogStream struct {
 	Level   Level
 	Labels  map[string]string
 	Entries []*LogEntry
+
+	// Tenant of a multi-tenant Loki, the exchanger's one is used when empty
+	TenantID string
 }
 
 type LogEntry struct {
 	Timestamp time.Time
 	Format    string
 	Args      []interface{}
+
+	// Structured metadata, attached to the entry without indexing (unlike labels)
+	Metadata map[string]string
 }
 
 const (
 	logLevelForcedLabel = "logLevel"
+
+	tenantHeader = "X-Scope-OrgID"
 )
 
 type StreamsExchanger interface {
@@ -36,6 +47,35 @@ type BasicAuthExchanger interface {
 	SetBasicAuth(username, password string)
 }
 
+//
+// Implemented by exchangers able to push into a multi-tenant Loki: streams are pushed
+// on behalf of their TenantID (or the one set here), via X-Scope-OrgID header
+//
+type TenantExchanger interface {
+	SetTenantID(tenantID string)
+}
+
+//
+// Implemented by exchangers able to render structured metadata into log lines
+// for Loki servers, which don't support it
+//
+type StructuredMetadataFallbackExchanger interface {
+	SetStructuredMetadataFallback(enabled bool)
+}
+
+//
+// Returned by exchangers when Loki responds with a non-successful status code,
+// used by the client to decide whether a push should be retried
+//
+type UnexpectedResponseError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *UnexpectedResponseError) Error() string {
+	return fmt.Sprintf("unexpected response code [code=%d], message: %s", e.StatusCode, e.Message)
+}
+
 //
 // Creates a client with direct send logic (nor batch neither queue) capable to
 // exchange with Loki v1 API via JSON
@@ -43,8 +83,7 @@ type BasicAuthExchanger interface {
 //
 func NewJSONv1Exchanger(lokiAddress string) StreamsExchanger {
 	return &lokiJsonV1Exchanger{
-		restClient:  &http.Client{},
-		lokiAddress: lokiAddress,
+		lokiRESTClient: newLokiRESTClient(lokiAddress),
 	}
 }
 
@@ -53,10 +92,7 @@ const (
 )
 
 type lokiJsonV1Exchanger struct {
-	restClient  *http.Client
-	lokiAddress string
-	username    string
-	password    string
+	lokiRESTClient
 }
 
 //
@@ -70,7 +106,7 @@ type lokiJsonV1Exchanger struct {
 //				},
 //				"values": [
 //					[ "<unix epoch in nanoseconds>", "<log line>" ],
-//					[ "<unix epoch in nanoseconds>", "<log line>" ]
+//					[ "<unix epoch in nanoseconds>", "<log line>", {"<metadata>": "<value>"} ]
 //				]
 //			}
 //		]
@@ -82,30 +118,148 @@ type (
 	}
 
 	lokiDTOJsonV1Stream struct {
-		Stream map[string]string `json:"stream"`
-		Values [][2]string       `json:"values"`
+		Stream map[string]string    `json:"stream"`
+		Values []lokiDTOJsonV1Value `json:"values"`
+	}
+
+	// Encoded as a tuple, where structured metadata is the optional third element
+	lokiDTOJsonV1Value struct {
+		Timestamp string
+		Line      string
+		Metadata  map[string]string
 	}
 )
 
+func (rcv lokiDTOJsonV1Value) MarshalJSON() ([]byte, error) {
+	if len(rcv.Metadata) == 0 {
+		return json.Marshal([2]string{rcv.Timestamp, rcv.Line})
+	}
+	return json.Marshal([]interface{}{rcv.Timestamp, rcv.Line, rcv.Metadata})
+}
+
+func (rcv *lokiDTOJsonV1Value) UnmarshalJSON(raw []byte) error {
+	var tuple []json.RawMessage
+	if err := json.Unmarshal(raw, &tuple); err != nil {
+		return err
+	}
+
+	if len(tuple) < 2 || len(tuple) > 3 {
+		return fmt.Errorf("value must consist of 2 or 3 elements, got: %d", len(tuple))
+	}
+
+	if err := json.Unmarshal(tuple[0], &rcv.Timestamp); err != nil {
+		return fmt.Errorf("invalid timestamp: %s", err)
+	}
+	if err := json.Unmarshal(tuple[1], &rcv.Line); err != nil {
+		return fmt.Errorf("invalid line: %s", err)
+	}
+	if len(tuple) == 3 {
+		if err := json.Unmarshal(tuple[2], &rcv.Metadata); err != nil {
+			return fmt.Errorf("invalid structured metadata: %s", err)
+		}
+	}
+
+	return nil
+}
+
 func (rcv *lokiJsonV1Exchanger) Push(streams []*LogStream) error {
-	var (
-		pushMessage       = rcv.transformLogStreamsToDTO(streams)
-		rawPushMessage, _ = json.Marshal(pushMessage)
-	)
+	var pushErr error
+
+	// Tenant is defined per request, so each tenant gets its own one
+	for _, tenantStreams := range groupStreamsByTenant(streams) {
+		var (
+			pushMessage       = rcv.transformLogStreamsToDTO(tenantStreams)
+			rawPushMessage, _ = json.Marshal(pushMessage)
+		)
+
+		if err := rcv.push("application/json", rawPushMessage, tenantStreams[0].TenantID); err != nil && pushErr == nil {
+			pushErr = err
+		}
+	}
+
+	return pushErr
+}
+
+func (rcv *lokiJsonV1Exchanger) transformLogStreamsToDTO(streams []*LogStream) *lokiDTOJsonV1PushRequest {
+	if streams == nil {
+		return nil
+	}
+
+	pushRequest := &lokiDTOJsonV1PushRequest{
+		Streams: make([]*lokiDTOJsonV1Stream, 0, len(streams)),
+	}
+
+	for i := range streams {
+		if streams[i] == nil || len(streams[i].Entries) == 0 {
+			continue
+		}
+
+		lokiStream := &lokiDTOJsonV1Stream{
+			Stream: streams[i].Labels,
+			Values: make([]lokiDTOJsonV1Value, 0, len(streams[i].Entries)),
+		}
+
+		for j := range streams[i].Entries {
+			if streams[i].Entries[j] == nil {
+				continue
+			}
+
+			line, metadata := rcv.formatEntry(streams[i].Level, streams[i].Entries[j])
+
+			lokiStream.Values = append(lokiStream.Values, lokiDTOJsonV1Value{
+				Timestamp: strconv.FormatInt(streams[i].Entries[j].Timestamp.UnixNano(), 10),
+				Line:      line,
+				Metadata:  metadata,
+			})
+		}
+
+		pushRequest.Streams = append(pushRequest.Streams, lokiStream)
+	}
+
+	return pushRequest
+}
+
+//
+// Shared HTTP routines of Loki API exchangers
+//
+type lokiRESTClient struct {
+	restClient  *http.Client
+	lokiAddress string
+	tenantID    string
 
+	authenticator Authenticator
+
+	structuredMetadataFallback bool
+}
+
+func newLokiRESTClient(lokiAddress string) lokiRESTClient {
+	return lokiRESTClient{
+		restClient:  &http.Client{},
+		lokiAddress: lokiAddress,
+	}
+}
+
+func (rcv *lokiRESTClient) push(contentType string, body []byte, tenantID string) error {
 	req, err := http.NewRequest(
 		"POST",
 		rcv.lokiAddress+"/loki/api/v1/push",
-		bytes.NewBuffer(rawPushMessage),
+		bytes.NewBuffer(body),
 	)
 	if err != nil {
 		return fmt.Errorf("failed to create request: %s", err)
 	}
 
-	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Content-Type", contentType)
 
-	if rcv.username != "" && rcv.password != "" {
-		req.SetBasicAuth(rcv.username, rcv.password)
+	if tenantID == "" {
+		tenantID = rcv.tenantID
+	}
+	if tenantID != "" {
+		req.Header.Set(tenantHeader, tenantID)
+	}
+
+	if err = rcv.authenticate(req); err != nil {
+		return err
 	}
 
 	resp, err := rcv.restClient.Do(req)
@@ -115,16 +269,18 @@ func (rcv *lokiJsonV1Exchanger) Push(streams []*LogStream) error {
 
 	defer func() { _ = resp.Body.Close() }()
 
-	if !(199 < resp.StatusCode && resp.StatusCode < 300) {
+	if !rcv.isSuccessHTTPCode(resp.StatusCode) {
 		messageBody, _ := ioutil.ReadAll(resp.Body)
-		return fmt.Errorf("unexpected response code [code=%d], message: %s",
-			resp.StatusCode, string(messageBody))
+		return &UnexpectedResponseError{
+			StatusCode: resp.StatusCode,
+			Message:    string(messageBody),
+		}
 	}
 
 	return nil
 }
 
-func (rcv *lokiJsonV1Exchanger) Ping() (*PongResponse, error) {
+func (rcv *lokiRESTClient) Ping() (*PongResponse, error) {
 	var (
 		timeout, cancel  = context.WithTimeout(context.Background(), requestTimeout)
 		pingRequest, err = http.NewRequestWithContext(timeout, http.MethodGet, rcv.lokiAddress+"/ready", nil)
@@ -135,6 +291,10 @@ func (rcv *lokiJsonV1Exchanger) Ping() (*PongResponse, error) {
 		return nil, fmt.Errorf("unable to build ping request: %s", err)
 	}
 
+	if err = rcv.authenticate(pingRequest); err != nil {
+		return nil, err
+	}
+
 	resp, err := rcv.restClient.Do(pingRequest)
 	if err != nil {
 		return nil, fmt.Errorf("pong is not received: %s", err)
@@ -151,51 +311,71 @@ func (rcv *lokiJsonV1Exchanger) Ping() (*PongResponse, error) {
 	return pong, nil
 }
 
-func (rcv *lokiJsonV1Exchanger) transformLogStreamsToDTO(streams []*LogStream) *lokiDTOJsonV1PushRequest {
-	if streams == nil {
+//
+// Basic auth is applied only if both username and password are set, otherwise
+// the authenticator set before (if any) is kept, so the order of options doesn't matter
+//
+func (rcv *lokiRESTClient) SetBasicAuth(username, password string) {
+	if username == "" || password == "" {
+		return
+	}
+
+	rcv.authenticator = BasicAuth(username, password)
+}
+
+func (rcv *lokiRESTClient) SetAuthenticator(authenticator Authenticator) {
+	rcv.authenticator = authenticator
+}
+
+func (rcv *lokiRESTClient) authenticate(req *http.Request) error {
+	if rcv.authenticator == nil {
 		return nil
 	}
 
-	pushRequest := &lokiDTOJsonV1PushRequest{
-		Streams: make([]*lokiDTOJsonV1Stream, 0, len(streams)),
+	if err := rcv.authenticator.Authenticate(req); err != nil {
+		return fmt.Errorf("failed to authenticate request: %s", err)
 	}
 
-	for i := range streams {
-		if streams[i] == nil || len(streams[i].Entries) == 0 {
-			continue
-		}
+	return nil
+}
 
-		lokiStream := &lokiDTOJsonV1Stream{
-			Stream: streams[i].Labels,
-			Values: make([][2]string, 0, len(streams[i].Entries)),
-		}
+func (rcv *lokiRESTClient) SetTenantID(tenantID string) {
+	rcv.tenantID = tenantID
+}
 
-		for j := range streams[i].Entries {
-			if streams[i].Entries[j] == nil {
-				continue
-			}
+func (rcv *lokiRESTClient) SetStructuredMetadataFallback(enabled bool) {
+	rcv.structuredMetadataFallback = enabled
+}
 
-			lokiStream.Values = append(lokiStream.Values, [2]string{
-				strconv.FormatInt(streams[i].Entries[j].Timestamp.UnixNano(), 10),
-				rcv.formatMessage(streams[i].Level, streams[i].Entries[j].Format, streams[i].Entries[j].Args...),
-			})
-		}
+//
+// Returns a line and structured metadata of the entry, with fallback enabled
+// metadata is rendered into the line as logfmt
+//
+func (rcv *lokiRESTClient) formatEntry(lvl Level, entry *LogEntry) (string, map[string]string) {
+	line := rcv.formatMessage(lvl, entry.Format, entry.Args...)
 
-		pushRequest.Streams = append(pushRequest.Streams, lokiStream)
+	if !rcv.structuredMetadataFallback || len(entry.Metadata) == 0 {
+		return line, entry.Metadata
 	}
 
-	return pushRequest
-}
+	keys := make([]string, 0, len(entry.Metadata))
+	for key := range entry.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	rendered := []byte(line)
+	for i := range keys {
+		rendered = logfmt.AppendPair(rendered, keys[i], entry.Metadata[keys[i]])
+	}
 
-func (rcv *lokiJsonV1Exchanger) SetBasicAuth(username, password string) {
-	rcv.username = username
-	rcv.password = password
+	return string(rendered), nil
 }
 
-func (rcv *lokiJsonV1Exchanger) formatMessage(lvl Level, format string, args ...interface{}) string {
+func (rcv *lokiRESTClient) formatMessage(lvl Level, format string, args ...interface{}) string {
 	return lvl.String() + ": " + fmt.Sprintf(format, args...)
 }
 
-func (rcv *lokiJsonV1Exchanger) isSuccessHTTPCode(code int) bool {
+func (rcv *lokiRESTClient) isSuccessHTTPCode(code int) bool {
 	return 199 < code && code < 300
 }
@@ -0,0 +1,201 @@
+package promtail
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+type FailoverBackend uint8
+
+const (
+	FailoverPrimary FailoverBackend = iota
+	FailoverSecondary
+)
+
+func (b FailoverBackend) String() string {
+	if b == FailoverSecondary {
+		return "secondary"
+	}
+	return "primary"
+}
+
+//
+// Describes a switch between backends of FailoverExchanger
+//
+type FailoverEvent struct {
+	// Backend which is used after the switch
+	Active FailoverBackend
+	// The last failure of the primary, nil for fail back
+	Err error
+}
+
+//
+// Creates an exchanger pushing to the primary, which switches to the secondary (e.g. DR Loki)
+// after failureThreshold consecutive failures of the primary. Meanwhile the primary is probed
+// with Ping() every probeInterval, and pushes fail back to it once it's ready.
+//	NOTE: only failures worth a retry (5xx, 429, network) are counted
+//
+func NewFailoverExchanger(
+	primary, secondary StreamsExchanger, failureThreshold uint, probeInterval time.Duration,
+) (*FailoverExchanger, error) {
+	if primary == nil || secondary == nil {
+		return nil, errors.New("both primary and secondary exchangers are required")
+	}
+
+	if failureThreshold == 0 {
+		return nil, errors.New("failure threshold must be positive")
+	}
+
+	if probeInterval <= 0 {
+		return nil, errors.New("probe interval must be positive")
+	}
+
+	return &FailoverExchanger{
+		primary:          primary,
+		secondary:        secondary,
+		failureThreshold: failureThreshold,
+		probeInterval:    probeInterval,
+		stopSignal:       make(chan struct{}),
+	}, nil
+}
+
+type FailoverExchanger struct {
+	primary          StreamsExchanger
+	secondary        StreamsExchanger
+	failureThreshold uint
+	probeInterval    time.Duration
+	eventHandler     func(event FailoverEvent)
+
+	mu                  sync.Mutex
+	active              FailoverBackend
+	consecutiveFailures uint
+
+	probing    sync.WaitGroup
+	stopSignal chan struct{}
+	stopOnce   sync.Once
+}
+
+//
+// Receives every switch between backends
+//	NOTE: it's called from pushing or probing goroutines, so keep it fast
+//
+func (rcv *FailoverExchanger) SetEventCallback(eventHandler func(event FailoverEvent)) {
+	rcv.eventHandler = eventHandler
+}
+
+// Returns the backend pushes are currently sent to
+func (rcv *FailoverExchanger) Active() FailoverBackend {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	return rcv.active
+}
+
+func (rcv *FailoverExchanger) Push(streams []*LogStream) error {
+	if rcv.Active() == FailoverSecondary {
+		return rcv.secondary.Push(streams)
+	}
+
+	err := rcv.primary.Push(streams)
+
+	rcv.mu.Lock()
+
+	if err == nil || !isRetryableError(err) {
+		if err == nil {
+			rcv.consecutiveFailures = 0
+		}
+		rcv.mu.Unlock()
+		return err
+	}
+
+	rcv.consecutiveFailures++
+
+	// Concurrent pushes may have switched already
+	isSwitched := rcv.active == FailoverPrimary && rcv.consecutiveFailures >= rcv.failureThreshold
+	if isSwitched {
+		rcv.active = FailoverSecondary
+		rcv.startProbing()
+	}
+	isSecondaryActive := rcv.active == FailoverSecondary
+
+	rcv.mu.Unlock()
+
+	if isSwitched {
+		rcv.notify(FailoverEvent{Active: FailoverSecondary, Err: err})
+	}
+
+	// The batch, failed on the primary, goes to the secondary right away
+	if isSecondaryActive {
+		return rcv.secondary.Push(streams)
+	}
+
+	return err
+}
+
+// Pings the backend pushes are currently sent to
+func (rcv *FailoverExchanger) Ping() (*PongResponse, error) {
+	if rcv.Active() == FailoverSecondary {
+		return rcv.secondary.Ping()
+	}
+	return rcv.primary.Ping()
+}
+
+//
+// Stops probing of the primary, call it once the client using the exchanger is closed
+//
+func (rcv *FailoverExchanger) Close() {
+	rcv.mu.Lock()
+	rcv.stopOnce.Do(func() {
+		close(rcv.stopSignal)
+	})
+	rcv.mu.Unlock()
+
+	rcv.probing.Wait()
+}
+
+// Must be called with the lock held, so probing never starts after Close()
+func (rcv *FailoverExchanger) startProbing() {
+	select {
+	case <-rcv.stopSignal:
+		return
+	default:
+	}
+
+	rcv.probing.Add(1)
+	go rcv.probePrimary()
+}
+
+func (rcv *FailoverExchanger) probePrimary() {
+	defer rcv.probing.Done()
+
+	ticker := time.NewTicker(rcv.probeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-rcv.stopSignal:
+			return
+
+		case <-ticker.C:
+			pong, err := rcv.primary.Ping()
+			if err != nil || pong == nil || !pong.IsReady {
+				continue
+			}
+
+			rcv.mu.Lock()
+			rcv.active = FailoverPrimary
+			rcv.consecutiveFailures = 0
+			rcv.mu.Unlock()
+
+			rcv.notify(FailoverEvent{Active: FailoverPrimary})
+			return
+		}
+	}
+}
+
+func (rcv *FailoverExchanger) notify(event FailoverEvent) {
+	if rcv.eventHandler != nil {
+		rcv.eventHandler(event)
+	}
+}
@@ -0,0 +1,125 @@
+// +build unit
+
+package promtail
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Exchanger, whose pushes fail and pings report not ready until it's recovered
+type recoverableExchanger struct {
+	fakeExchanger
+	isRecovered int32
+}
+
+func (rcv *recoverableExchanger) Push(streams []*LogStream) error {
+	if atomic.LoadInt32(&rcv.isRecovered) == 0 {
+		rcv.mu.Lock()
+		rcv.attempts++
+		rcv.mu.Unlock()
+		return &UnexpectedResponseError{StatusCode: http.StatusServiceUnavailable}
+	}
+	return rcv.fakeExchanger.Push(streams)
+}
+
+func (rcv *recoverableExchanger) Ping() (*PongResponse, error) {
+	return &PongResponse{IsReady: atomic.LoadInt32(&rcv.isRecovered) == 1}, nil
+}
+
+func TestFailoverExchanger(t *testing.T) {
+	var (
+		primary   = &recoverableExchanger{}
+		secondary = &fakeExchanger{}
+
+		mu     sync.Mutex
+		events []FailoverEvent
+		failed = make(chan struct{}, 1)
+		backed = make(chan struct{}, 1)
+	)
+
+	failover, err := NewFailoverExchanger(primary, secondary, 2, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("unexpected error on initialization: %s", err)
+	}
+	defer failover.Close()
+
+	failover.SetEventCallback(func(event FailoverEvent) {
+		mu.Lock()
+		events = append(events, event)
+		mu.Unlock()
+
+		if event.Active == FailoverSecondary {
+			failed <- struct{}{}
+		} else {
+			backed <- struct{}{}
+		}
+	})
+
+	// The first failure is returned to be retried, the second one switches to the secondary
+	if err = failover.Push(newTestStreams(1)); err == nil {
+		t.Error("expected error before failure threshold is reached, but not occurred")
+	}
+	if err = failover.Push(newTestStreams(1)); err != nil {
+		t.Errorf("batch is expected to be pushed to the secondary, got error: %s", err)
+	}
+	<-failed
+
+	if failover.Active() != FailoverSecondary || secondary.countPushedEntries() != 1 {
+		t.Fatalf("pushes are not switched to the secondary, active: %s", failover.Active())
+	}
+
+	// Primary is not used while it's not ready
+	_ = failover.Push(newTestStreams(1))
+	if primary.countAttempts() != 2 || secondary.countPushedEntries() != 2 {
+		t.Errorf("unexpected pushes, primary: %d, secondary: %d", primary.countAttempts(), secondary.countPushedEntries())
+	}
+
+	atomic.StoreInt32(&primary.isRecovered, 1)
+
+	select {
+	case <-backed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("pushes didn't fail back to the recovered primary")
+	}
+
+	if err = failover.Push(newTestStreams(1)); err != nil || primary.countPushedEntries() != 1 {
+		t.Errorf("batch is expected to be pushed to the primary, error: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(events) != 2 || events[0].Active != FailoverSecondary || events[0].Err == nil ||
+		events[1].Active != FailoverPrimary || events[1].Err != nil {
+		t.Errorf("unexpected events: %+v", events)
+	}
+}
+
+func TestFailoverExchanger_ClientErrorsAreNotCounted(t *testing.T) {
+	var (
+		badRequest = &UnexpectedResponseError{StatusCode: http.StatusBadRequest}
+		primary    = &fakeExchanger{failures: []error{badRequest, badRequest, badRequest}}
+		secondary  = &fakeExchanger{}
+	)
+
+	failover, err := NewFailoverExchanger(primary, secondary, 2, time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error on initialization: %s", err)
+	}
+	defer failover.Close()
+
+	for i := 0; i < 3; i++ {
+		if err = failover.Push(newTestStreams(1)); !errors.Is(err, badRequest) {
+			t.Errorf("Push() error = %v, want %v", err, badRequest)
+		}
+	}
+
+	if failover.Active() != FailoverPrimary || secondary.countAttempts() != 0 {
+		t.Error("rejected batches must not switch pushes to the secondary")
+	}
+}
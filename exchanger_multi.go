@@ -0,0 +1,166 @@
+package promtail
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+//
+// Defines when a push to several backends is considered successful
+//
+type MultiExchangerMode uint8
+
+const (
+	// Every backend has to accept the batch, otherwise it's retried on all of them
+	AllMustSucceed MultiExchangerMode = iota
+	// At least one backend has to accept the batch
+	AnySuccess
+	// Push never fails, failures of backends are reported to the error callback only
+	BestEffort
+)
+
+//
+// Creates an exchanger pushing every batch to all the exchangers concurrently,
+// e.g. to dual-write into old and new Loki clusters during a migration
+//	NOTE: backends are configured (auth, TLS, tenants) on their own before composing
+//
+func NewMultiExchanger(mode MultiExchangerMode, exchangers ...StreamsExchanger) (*MultiExchanger, error) {
+	if len(exchangers) == 0 {
+		return nil, errors.New("at least one exchanger is required")
+	}
+
+	for i := range exchangers {
+		if exchangers[i] == nil {
+			return nil, fmt.Errorf("exchanger #%d is nil", i)
+		}
+	}
+
+	return &MultiExchanger{
+		mode:       mode,
+		exchangers: exchangers,
+	}, nil
+}
+
+type MultiExchanger struct {
+	mode         MultiExchangerMode
+	exchangers   []StreamsExchanger
+	errorHandler func(backend int, err error)
+}
+
+//
+// Returned when a push or ping fails on some of backends, errors are indexed
+// as exchangers are passed to NewMultiExchanger (nil for succeeded backends)
+//
+type MultiExchangerError struct {
+	Errors []error
+}
+
+func (e *MultiExchangerError) Error() string {
+	var (
+		failed   = 0
+		messages []string
+	)
+
+	for i := range e.Errors {
+		if e.Errors[i] != nil {
+			failed++
+			messages = append(messages, fmt.Sprintf("[backend=%d]: %s", i, e.Errors[i]))
+		}
+	}
+
+	return fmt.Sprintf("failed on %d of %d backends, %s", failed, len(e.Errors), strings.Join(messages, ", "))
+}
+
+//
+// Receives every failure of a backend along with its index, whatever the mode is
+//	NOTE: it's called concurrently from pushing goroutines
+//
+func (rcv *MultiExchanger) SetErrorCallback(errorHandler func(backend int, err error)) {
+	rcv.errorHandler = errorHandler
+}
+
+func (rcv *MultiExchanger) Push(streams []*LogStream) error {
+	errs := rcv.forEach(func(_ int, exchanger StreamsExchanger) error {
+		return exchanger.Push(streams)
+	})
+
+	failed := 0
+	for i := range errs {
+		if errs[i] != nil {
+			failed++
+			if rcv.errorHandler != nil {
+				rcv.errorHandler(i, errs[i])
+			}
+		}
+	}
+
+	switch {
+	case failed == 0:
+		return nil
+	case rcv.mode == BestEffort:
+		return nil
+	case rcv.mode == AnySuccess && failed < len(errs):
+		return nil
+	default:
+		return &MultiExchangerError{Errors: errs}
+	}
+}
+
+//
+// Pings all backends, readiness follows the mode: every backend has to be ready
+// for AllMustSucceed, and any of them for others. Error is returned if no backend answered.
+//
+func (rcv *MultiExchanger) Ping() (*PongResponse, error) {
+	pongs := make([]*PongResponse, len(rcv.exchangers))
+
+	errs := rcv.forEach(func(i int, exchanger StreamsExchanger) (err error) {
+		pongs[i], err = exchanger.Ping()
+		return err
+	})
+
+	var (
+		answered = 0
+		ready    = 0
+	)
+
+	for i := range pongs {
+		if errs[i] == nil && pongs[i] != nil {
+			answered++
+			if pongs[i].IsReady {
+				ready++
+			}
+		}
+	}
+
+	if answered == 0 {
+		return nil, &MultiExchangerError{Errors: errs}
+	}
+
+	if rcv.mode == AllMustSucceed {
+		return &PongResponse{IsReady: ready == len(rcv.exchangers)}, nil
+	}
+	return &PongResponse{IsReady: ready > 0}, nil
+}
+
+// Runs the action for every exchanger concurrently, returns errors indexed as exchangers
+func (rcv *MultiExchanger) forEach(action func(i int, exchanger StreamsExchanger) error) []error {
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, len(rcv.exchangers))
+	)
+
+	for i := range rcv.exchangers {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = action(i, rcv.exchangers[i])
+		}(i)
+	}
+
+	wg.Wait()
+
+	return errs
+}
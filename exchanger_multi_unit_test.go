@@ -0,0 +1,165 @@
+// +build unit
+
+package promtail
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+type pingExchanger struct {
+	fakeExchanger
+	pong *PongResponse
+	err  error
+}
+
+func (rcv *pingExchanger) Ping() (*PongResponse, error) {
+	return rcv.pong, rcv.err
+}
+
+func TestMultiExchanger_Push(t *testing.T) {
+	var (
+		serverErr = &UnexpectedResponseError{StatusCode: http.StatusBadGateway}
+		clientErr = &UnexpectedResponseError{StatusCode: http.StatusBadRequest}
+	)
+
+	tests := []struct {
+		name          string
+		mode          MultiExchangerMode
+		failures      []error
+		wantErr       bool
+		wantRetryable bool
+	}{
+		{name: "All must succeed, all succeeded", mode: AllMustSucceed, failures: []error{nil, nil}},
+		{name: "All must succeed, one failed", mode: AllMustSucceed, failures: []error{nil, serverErr}, wantErr: true, wantRetryable: true},
+		{name: "All must succeed, rejected", mode: AllMustSucceed, failures: []error{clientErr, nil}, wantErr: true},
+		{name: "Any success, one failed", mode: AnySuccess, failures: []error{serverErr, nil}},
+		{name: "Any success, all failed", mode: AnySuccess, failures: []error{serverErr, clientErr}, wantErr: true, wantRetryable: true},
+		{name: "Best effort, all failed", mode: BestEffort, failures: []error{serverErr, clientErr}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				exchangers []StreamsExchanger
+				fakes      []*fakeExchanger
+			)
+
+			for i := range tt.failures {
+				fake := &fakeExchanger{}
+				if tt.failures[i] != nil {
+					fake.failures = []error{tt.failures[i]}
+				}
+				fakes = append(fakes, fake)
+				exchangers = append(exchangers, fake)
+			}
+
+			multiExchanger, err := NewMultiExchanger(tt.mode, exchangers...)
+			if err != nil {
+				t.Fatalf("unexpected error on initialization: %s", err)
+			}
+
+			var (
+				mu       sync.Mutex
+				reported = make(map[int]error)
+			)
+			multiExchanger.SetErrorCallback(func(backend int, err error) {
+				mu.Lock()
+				reported[backend] = err
+				mu.Unlock()
+			})
+
+			err = multiExchanger.Push(newTestStreams(1))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Push() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && isRetryableError(err) != tt.wantRetryable {
+				t.Errorf("isRetryableError() = %v, want %v", !tt.wantRetryable, tt.wantRetryable)
+			}
+
+			// Every backend is tried and every failure is reported
+			for i := range fakes {
+				if fakes[i].countAttempts() != 1 {
+					t.Errorf("backend #%d is pushed %d times, want once", i, fakes[i].countAttempts())
+				}
+				if reported[i] != tt.failures[i] {
+					t.Errorf("backend #%d reported error = %v, want %v", i, reported[i], tt.failures[i])
+				}
+			}
+		})
+	}
+}
+
+func TestMultiExchanger_PushConcurrently(t *testing.T) {
+	var (
+		first  = newBlockingExchanger()
+		second = newBlockingExchanger()
+	)
+
+	multiExchanger, err := NewMultiExchanger(AllMustSucceed, first, second)
+	if err != nil {
+		t.Fatalf("unexpected error on initialization: %s", err)
+	}
+
+	done := make(chan error)
+	go func() { done <- multiExchanger.Push(newTestStreams(1)) }()
+
+	// Both pushes are started while none of them is finished
+	for _, exchanger := range []*blockingExchanger{first, second} {
+		select {
+		case <-exchanger.pushStarted:
+		case <-time.After(5 * time.Second):
+			t.Fatal("pushes are not concurrent")
+		}
+	}
+
+	close(first.release)
+	close(second.release)
+
+	if err = <-done; err != nil {
+		t.Errorf("unexpected push error: %s", err)
+	}
+}
+
+func TestMultiExchanger_Ping(t *testing.T) {
+	var (
+		ready    = func() StreamsExchanger { return &pingExchanger{pong: &PongResponse{IsReady: true}} }
+		notReady = func() StreamsExchanger { return &pingExchanger{pong: &PongResponse{IsReady: false}} }
+		down     = func() StreamsExchanger { return &pingExchanger{err: errors.New("connection refused")} }
+	)
+
+	tests := []struct {
+		name       string
+		mode       MultiExchangerMode
+		exchangers []StreamsExchanger
+		wantReady  bool
+		wantErr    bool
+	}{
+		{name: "All ready", mode: AllMustSucceed, exchangers: []StreamsExchanger{ready(), ready()}, wantReady: true},
+		{name: "All must be ready", mode: AllMustSucceed, exchangers: []StreamsExchanger{ready(), notReady()}},
+		{name: "Down is not ready", mode: AllMustSucceed, exchangers: []StreamsExchanger{ready(), down()}},
+		{name: "Any ready", mode: AnySuccess, exchangers: []StreamsExchanger{down(), ready()}, wantReady: true},
+		{name: "None ready", mode: BestEffort, exchangers: []StreamsExchanger{notReady(), down()}},
+		{name: "All down", mode: AnySuccess, exchangers: []StreamsExchanger{down(), down()}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			multiExchanger, err := NewMultiExchanger(tt.mode, tt.exchangers...)
+			if err != nil {
+				t.Fatalf("unexpected error on initialization: %s", err)
+			}
+
+			pong, err := multiExchanger.Ping()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Ping() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && pong.IsReady != tt.wantReady {
+				t.Errorf("Ping() ready = %v, want %v", pong.IsReady, tt.wantReady)
+			}
+		})
+	}
+}
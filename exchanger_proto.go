@@ -0,0 +1,75 @@
+package promtail
+
+import (
+	"github.com/ic2hrmk/promtail/internal/logproto"
+	"github.com/ic2hrmk/promtail/internal/snappy"
+)
+
+//
+// Creates a client with direct send logic (nor batch neither queue) capable to
+// exchange with Loki v1 API via snappy-compressed protobuf
+//	Read more at: https://github.com/grafana/loki/blob/master/docs/api.md#post-lokiapiv1push
+//
+func NewProtoV1Exchanger(lokiAddress string) StreamsExchanger {
+	return &lokiProtoV1Exchanger{
+		lokiRESTClient: newLokiRESTClient(lokiAddress),
+	}
+}
+
+type lokiProtoV1Exchanger struct {
+	lokiRESTClient
+}
+
+func (rcv *lokiProtoV1Exchanger) Push(streams []*LogStream) error {
+	var pushErr error
+
+	// Tenant is defined per request, so each tenant gets its own one
+	for _, tenantStreams := range groupStreamsByTenant(streams) {
+		var (
+			pushMessage       = rcv.transformLogStreamsToProto(tenantStreams)
+			rawPushMessage    = pushMessage.Marshal()
+			packedPushMessage = snappy.Encode(rawPushMessage)
+		)
+
+		if err := rcv.push("application/x-protobuf", packedPushMessage, tenantStreams[0].TenantID); err != nil && pushErr == nil {
+			pushErr = err
+		}
+	}
+
+	return pushErr
+}
+
+func (rcv *lokiProtoV1Exchanger) transformLogStreamsToProto(streams []*LogStream) *logproto.PushRequest {
+	pushRequest := &logproto.PushRequest{
+		Streams: make([]logproto.Stream, 0, len(streams)),
+	}
+
+	for i := range streams {
+		if streams[i] == nil || len(streams[i].Entries) == 0 {
+			continue
+		}
+
+		lokiStream := logproto.Stream{
+			Labels:  logproto.FormatLabels(streams[i].Labels),
+			Entries: make([]logproto.Entry, 0, len(streams[i].Entries)),
+		}
+
+		for j := range streams[i].Entries {
+			if streams[i].Entries[j] == nil {
+				continue
+			}
+
+			line, metadata := rcv.formatEntry(streams[i].Level, streams[i].Entries[j])
+
+			lokiStream.Entries = append(lokiStream.Entries, logproto.Entry{
+				Timestamp:          streams[i].Entries[j].Timestamp,
+				Line:               line,
+				StructuredMetadata: logproto.FromLabelsMap(metadata),
+			})
+		}
+
+		pushRequest.Streams = append(pushRequest.Streams, lokiStream)
+	}
+
+	return pushRequest
+}
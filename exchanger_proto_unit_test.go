@@ -0,0 +1,129 @@
+// +build unit
+
+package promtail
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ic2hrmk/promtail/internal/logproto"
+	"github.com/ic2hrmk/promtail/internal/snappy"
+)
+
+func Test_LokiProtoV1Exchanger_Push(t *testing.T) {
+	var (
+		timestamp = time.Now()
+		received  = make(chan *logproto.PushRequest, 1)
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/loki/api/v1/push" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		if contentType := r.Header.Get("Content-Type"); contentType != "application/x-protobuf" {
+			t.Errorf("unexpected content type: %s", contentType)
+		}
+		if username, password, _ := r.BasicAuth(); username != "user" || password != "secret" {
+			t.Errorf("basic auth is not set, got: %s:%s", username, password)
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		raw, err := snappy.Decode(body)
+		if err != nil {
+			t.Errorf("body is not snappy-compressed: %s", err)
+		}
+		req, err := logproto.Unmarshal(raw)
+		if err != nil {
+			t.Errorf("body is not a push request: %s", err)
+		}
+
+		received <- req
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	exchanger := NewProtoV1Exchanger(server.URL)
+	exchanger.(BasicAuthExchanger).SetBasicAuth("user", "secret")
+
+	err := exchanger.Push([]*LogStream{
+		{
+			Level:  Warn,
+			Labels: map[string]string{"instanceId": "instance-a1", logLevelForcedLabel: Warn.String()},
+			Entries: []*LogEntry{
+				{Timestamp: timestamp, Format: "disk usage is %d%%", Args: []interface{}{95}},
+				{Timestamp: timestamp, Format: "disk is full", Metadata: map[string]string{"mount": "/", "device": "sda"}},
+			},
+		},
+		{
+			Level:  Info,
+			Labels: map[string]string{"instanceId": "instance-a1"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on push: %s", err)
+	}
+
+	want := &logproto.PushRequest{
+		Streams: []logproto.Stream{
+			{
+				Labels: `{instanceId="instance-a1", logLevel="WARN"}`,
+				Entries: []logproto.Entry{
+					{Timestamp: time.Unix(0, timestamp.UnixNano()), Line: "WARN: disk usage is 95%"},
+					{
+						Timestamp: time.Unix(0, timestamp.UnixNano()),
+						Line:      "WARN: disk is full",
+						StructuredMetadata: []logproto.LabelPair{
+							{Name: "device", Value: "sda"},
+							{Name: "mount", Value: "/"},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if got := <-received; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected push request:\n got  = %+v\n want = %+v", got, want)
+	}
+}
+
+func Test_LokiProtoV1Exchanger_Push_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = fmt.Fprint(w, "entry out of order")
+	}))
+	defer server.Close()
+
+	err := NewProtoV1Exchanger(server.URL).Push([]*LogStream{
+		newLeveledStream(Info, map[string]string{"instanceId": "instance-a1"}),
+	})
+	if err == nil {
+		t.Fatal("expected error on bad request, but not occurred")
+	}
+}
+
+func Test_Logproto_Labels(t *testing.T) {
+	labels := map[string]string{
+		"instanceId": "instance-a1",
+		"quoted":     `say "hi", \ok`,
+		"empty":      "",
+	}
+
+	formatted := logproto.FormatLabels(labels)
+	if want := `{empty="", instanceId="instance-a1", quoted="say \"hi\", \\ok"}`; formatted != want {
+		t.Errorf("unexpected labels format:\n got  = %s\n want = %s", formatted, want)
+	}
+
+	parsed, err := logproto.ParseLabels(formatted)
+	if err != nil {
+		t.Fatalf("unexpected error on labels parsing: %s", err)
+	}
+	if !reflect.DeepEqual(parsed, labels) {
+		t.Errorf("parsed labels don't match, got: %v, want: %v", parsed, labels)
+	}
+}
@@ -5,8 +5,11 @@ package promtail
 import (
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"strconv"
+	"sync"
 	"testing"
 	"time"
 )
@@ -48,15 +51,46 @@ func Test_LokiJSONv1Exchanger_transformLogStreamsToDTO(t *testing.T) {
 								"instanceId": "instance-a1",
 							},
 						),
-						Values: [][2]string{{
-							strconv.FormatInt(timestamp.UnixNano(), 10),
-							Error.String() + ": " +
+						Values: []lokiDTOJsonV1Value{{
+							Timestamp: strconv.FormatInt(timestamp.UnixNano(), 10),
+							Line: Error.String() + ": " +
 								fmt.Sprintf("regular error message, nothing to do with [%s] :)", []interface{}{"awesome argument"}...),
 						}},
 					},
 				},
 			},
 		},
+		{
+			name: "Transformation with structured metadata",
+			args: args{
+				streams: []*LogStream{
+					{
+						Level:  Info,
+						Labels: map[string]string{"instanceId": "instance-a1"},
+						Entries: []*LogEntry{
+							{
+								Timestamp: timestamp,
+								Format:    "%s",
+								Args:      []interface{}{"user logged in"},
+								Metadata:  map[string]string{"userId": "42"},
+							},
+						},
+					},
+				},
+			},
+			want: &lokiDTOJsonV1PushRequest{
+				Streams: []*lokiDTOJsonV1Stream{
+					{
+						Stream: map[string]string{"instanceId": "instance-a1"},
+						Values: []lokiDTOJsonV1Value{{
+							Timestamp: strconv.FormatInt(timestamp.UnixNano(), 10),
+							Line:      "INFO: user logged in",
+							Metadata:  map[string]string{"userId": "42"},
+						}},
+					},
+				},
+			},
+		},
 		{
 			name: "NIL transformation",
 			args: args{streams: nil},
@@ -132,3 +166,102 @@ func Test_LokiJSONv1Exchanger_format(t *testing.T) {
 		})
 	}
 }
+
+func Test_LokiJSONv1Exchanger_StructuredMetadata(t *testing.T) {
+	var (
+		timestamp = time.Unix(1, 5)
+		streams   = []*LogStream{
+			{
+				Level:  Info,
+				Labels: map[string]string{"instanceId": "instance-a1"},
+				Entries: []*LogEntry{
+					{Timestamp: timestamp, Format: "plain"},
+					{Timestamp: timestamp, Format: "with metadata", Metadata: map[string]string{"userId": "42", "path": "/a b"}},
+				},
+			},
+		}
+		exchanger = NewJSONv1Exchanger("loki").(*lokiJsonV1Exchanger)
+	)
+
+	raw, _ := json.Marshal(exchanger.transformLogStreamsToDTO(streams))
+	want := `{"streams":[{"stream":{"instanceId":"instance-a1"},"values":[` +
+		`["1000000005","INFO: plain"],` +
+		`["1000000005","INFO: with metadata",{"path":"/a b","userId":"42"}]]}]}`
+	if string(raw) != want {
+		t.Errorf("unexpected push message:\n got  = %s\n want = %s", raw, want)
+	}
+
+	// Decoding restores the same values
+	decoded := &lokiDTOJsonV1PushRequest{}
+	if err := json.Unmarshal(raw, decoded); err != nil {
+		t.Fatalf("unexpected error on decoding: %s", err)
+	}
+	if !reflect.DeepEqual(decoded, exchanger.transformLogStreamsToDTO(streams)) {
+		t.Errorf("decoded push message doesn't match the encoded one")
+	}
+
+	// Fallback for older servers renders metadata into the line
+	exchanger.SetStructuredMetadataFallback(true)
+
+	raw, _ = json.Marshal(exchanger.transformLogStreamsToDTO(streams))
+	want = `{"streams":[{"stream":{"instanceId":"instance-a1"},"values":[` +
+		`["1000000005","INFO: plain"],` +
+		`["1000000005","INFO: with metadata path=\"/a b\" userId=42"]]}]}`
+	if string(raw) != want {
+		t.Errorf("unexpected push message with fallback:\n got  = %s\n want = %s", raw, want)
+	}
+}
+
+func Test_LokiJSONv1Exchanger_Tenants(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		received = make(map[string][]string)
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pushRequest := &lokiDTOJsonV1PushRequest{}
+		if err := json.NewDecoder(r.Body).Decode(pushRequest); err != nil {
+			t.Errorf("failed to decode push request: %s", err)
+		}
+
+		mu.Lock()
+		for _, stream := range pushRequest.Streams {
+			tenantID := r.Header.Get("X-Scope-OrgID")
+			received[tenantID] = append(received[tenantID], stream.Stream["app"])
+		}
+		mu.Unlock()
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	newStream := func(app, tenantID string) *LogStream {
+		return &LogStream{
+			Labels:   map[string]string{"app": app},
+			Entries:  []*LogEntry{{Timestamp: time.Now(), Format: "started"}},
+			TenantID: tenantID,
+		}
+	}
+
+	exchanger := NewJSONv1Exchanger(server.URL)
+	exchanger.(TenantExchanger).SetTenantID("default")
+
+	err := exchanger.Push([]*LogStream{
+		newStream("a", "team-a"),
+		newStream("b", ""),
+		newStream("c", "team-a"),
+		newStream("d", "team-b"),
+	})
+	if err != nil {
+		t.Fatalf("unexpected push error: %s", err)
+	}
+
+	want := map[string][]string{
+		"team-a":  {"a", "c"},
+		"team-b":  {"d"},
+		"default": {"b"},
+	}
+	if !reflect.DeepEqual(received, want) {
+		t.Errorf("unexpected streams of tenants:\n got  = %v\n want = %v", received, want)
+	}
+}
@@ -0,0 +1,111 @@
+package logproto
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Formats labels in a Prometheus notation with sorted names: {a="1", b="2"}
+func FormatLabels(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	sb := strings.Builder{}
+	sb.WriteByte('{')
+	for i := range names {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(names[i])
+		sb.WriteByte('=')
+		sb.WriteString(strconv.Quote(labels[names[i]]))
+	}
+	sb.WriteByte('}')
+
+	return sb.String()
+}
+
+// Parses labels from a Prometheus notation produced by FormatLabels
+func ParseLabels(s string) (map[string]string, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "{") || !strings.HasSuffix(s, "}") {
+		return nil, fmt.Errorf("logproto: labels must be enclosed in braces: %s", s)
+	}
+	s = strings.TrimSpace(s[1 : len(s)-1])
+
+	labels := make(map[string]string)
+
+	for s != "" {
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 {
+			return nil, fmt.Errorf("logproto: missing label value in: %s", s)
+		}
+		name := strings.TrimSpace(s[:eq])
+
+		s = strings.TrimSpace(s[eq+1:])
+
+		quoted := quotedPrefix(s)
+		value, err := strconv.Unquote(quoted)
+		if err != nil {
+			return nil, fmt.Errorf("logproto: invalid value of label [%s]: %s", name, err)
+		}
+		labels[name] = value
+
+		s = s[len(quoted):]
+		s = strings.TrimPrefix(strings.TrimSpace(s), ",")
+		s = strings.TrimSpace(s)
+	}
+
+	return labels, nil
+}
+
+// Returns a double-quoted string at the beginning of s (or an empty string)
+func quotedPrefix(s string) string {
+	if !strings.HasPrefix(s, `"`) {
+		return ""
+	}
+
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return s[:i+1]
+		}
+	}
+
+	return ""
+}
+
+// Converts a map into label pairs sorted by name
+func FromLabelsMap(labels map[string]string) []LabelPair {
+	if len(labels) == 0 {
+		return nil
+	}
+
+	pairs := make([]LabelPair, 0, len(labels))
+	for name, value := range labels {
+		pairs = append(pairs, LabelPair{Name: name, Value: value})
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].Name < pairs[j].Name })
+
+	return pairs
+}
+
+func ToLabelsMap(pairs []LabelPair) map[string]string {
+	if len(pairs) == 0 {
+		return nil
+	}
+
+	labels := make(map[string]string, len(pairs))
+	for i := range pairs {
+		labels[pairs[i].Name] = pairs[i].Value
+	}
+
+	return labels
+}
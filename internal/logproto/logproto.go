@@ -0,0 +1,274 @@
+//
+// Package logproto contains a hand-written protobuf codec for Loki's push
+// request, so the client keeps zero external dependencies.
+//	Messages are restored from `pkg/push/push.proto`:
+//		https://github.com/grafana/loki/blob/main/pkg/push/push.proto
+//	message PushRequest {
+//		repeated StreamAdapter streams = 1;
+//	}
+//	message StreamAdapter {
+//		string labels = 1;
+//		repeated EntryAdapter entries = 2;
+//	}
+//	message EntryAdapter {
+//		google.protobuf.Timestamp timestamp = 1;
+//		string line = 2;
+//		repeated LabelPairAdapter structuredMetadata = 3;
+//	}
+//	message LabelPairAdapter {
+//		string name = 1;
+//		string value = 2;
+//	}
+//
+package logproto
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type PushRequest struct {
+	Streams []Stream
+}
+
+type Stream struct {
+	// Labels in a Prometheus notation, e.g. {job="app", logLevel="INFO"}
+	Labels  string
+	Entries []Entry
+}
+
+type Entry struct {
+	Timestamp          time.Time
+	Line               string
+	StructuredMetadata []LabelPair
+}
+
+type LabelPair struct {
+	Name  string
+	Value string
+}
+
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+var ErrMalformed = errors.New("logproto: malformed message")
+
+//
+// Marshaling
+//
+
+func (rcv *PushRequest) Marshal() []byte {
+	var buf []byte
+	for i := range rcv.Streams {
+		buf = appendMessage(buf, 1, rcv.Streams[i].marshal())
+	}
+	return buf
+}
+
+func (rcv *Stream) marshal() []byte {
+	var buf []byte
+	buf = appendString(buf, 1, rcv.Labels)
+	for i := range rcv.Entries {
+		buf = appendMessage(buf, 2, rcv.Entries[i].marshal())
+	}
+	return buf
+}
+
+func (rcv *Entry) marshal() []byte {
+	var buf []byte
+	buf = appendMessage(buf, 1, marshalTimestamp(rcv.Timestamp))
+	buf = appendString(buf, 2, rcv.Line)
+	for i := range rcv.StructuredMetadata {
+		buf = appendMessage(buf, 3, rcv.StructuredMetadata[i].marshal())
+	}
+	return buf
+}
+
+func (rcv *LabelPair) marshal() []byte {
+	var buf []byte
+	buf = appendString(buf, 1, rcv.Name)
+	buf = appendString(buf, 2, rcv.Value)
+	return buf
+}
+
+func marshalTimestamp(ts time.Time) []byte {
+	var buf []byte
+	if seconds := ts.Unix(); seconds != 0 {
+		buf = appendVarint(buf, 1, uint64(seconds))
+	}
+	if nanos := ts.Nanosecond(); nanos != 0 {
+		buf = appendVarint(buf, 2, uint64(nanos))
+	}
+	return buf
+}
+
+func appendTag(buf []byte, field int, wireType int) []byte {
+	return appendUvarint(buf, uint64(field)<<3|uint64(wireType))
+}
+
+func appendVarint(buf []byte, field int, v uint64) []byte {
+	buf = appendTag(buf, field, wireVarint)
+	return appendUvarint(buf, v)
+}
+
+func appendString(buf []byte, field int, s string) []byte {
+	if s == "" {
+		return buf
+	}
+	buf = appendTag(buf, field, wireBytes)
+	buf = appendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func appendMessage(buf []byte, field int, msg []byte) []byte {
+	buf = appendTag(buf, field, wireBytes)
+	buf = appendUvarint(buf, uint64(len(msg)))
+	return append(buf, msg...)
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var scratch [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(scratch[:], v)
+	return append(buf, scratch[:n]...)
+}
+
+//
+// Unmarshaling
+//
+
+func Unmarshal(data []byte) (*PushRequest, error) {
+	req := &PushRequest{}
+
+	err := walkFields(data, func(field int, raw []byte, _ uint64) error {
+		if field != 1 {
+			return nil
+		}
+
+		stream, err := unmarshalStream(raw)
+		if err != nil {
+			return err
+		}
+
+		req.Streams = append(req.Streams, stream)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+func unmarshalStream(data []byte) (Stream, error) {
+	stream := Stream{}
+
+	err := walkFields(data, func(field int, raw []byte, _ uint64) error {
+		switch field {
+		case 1:
+			stream.Labels = string(raw)
+		case 2:
+			entry, err := unmarshalEntry(raw)
+			if err != nil {
+				return err
+			}
+			stream.Entries = append(stream.Entries, entry)
+		}
+		return nil
+	})
+
+	return stream, err
+}
+
+func unmarshalEntry(data []byte) (Entry, error) {
+	var (
+		entry          = Entry{}
+		seconds, nanos int64
+	)
+
+	err := walkFields(data, func(field int, raw []byte, _ uint64) error {
+		switch field {
+		case 1:
+			return walkFields(raw, func(field int, _ []byte, v uint64) error {
+				switch field {
+				case 1:
+					seconds = int64(v)
+				case 2:
+					nanos = int64(v)
+				}
+				return nil
+			})
+		case 2:
+			entry.Line = string(raw)
+		case 3:
+			pair := LabelPair{}
+			err := walkFields(raw, func(field int, raw []byte, _ uint64) error {
+				switch field {
+				case 1:
+					pair.Name = string(raw)
+				case 2:
+					pair.Value = string(raw)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			entry.StructuredMetadata = append(entry.StructuredMetadata, pair)
+		}
+		return nil
+	})
+
+	entry.Timestamp = time.Unix(seconds, nanos)
+
+	return entry, err
+}
+
+// Calls visitor for every field, passing raw bytes for length-delimited fields and value for varints
+func walkFields(data []byte, visitor func(field int, raw []byte, v uint64) error) error {
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		if n <= 0 {
+			return ErrMalformed
+		}
+		data = data[n:]
+
+		var (
+			field    = int(tag >> 3)
+			wireType = int(tag & 0x07)
+		)
+
+		switch wireType {
+		case wireVarint:
+			v, n := binary.Uvarint(data)
+			if n <= 0 {
+				return ErrMalformed
+			}
+			data = data[n:]
+
+			if err := visitor(field, nil, v); err != nil {
+				return err
+			}
+
+		case wireBytes:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < length {
+				return ErrMalformed
+			}
+			raw := data[n : n+int(length)]
+			data = data[n+int(length):]
+
+			if err := visitor(field, raw, 0); err != nil {
+				return err
+			}
+
+		default:
+			return fmt.Errorf("logproto: unsupported wire type %d", wireType)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,228 @@
+//
+// Package snappy implements the block format of Snappy compression used by
+// Loki's push API (no framing, no streaming).
+//	Read more at: https://github.com/google/snappy/blob/master/format_description.txt
+//
+package snappy
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	tagLiteral = 0x00
+	tagCopy1   = 0x01
+	tagCopy2   = 0x02
+	tagCopy4   = 0x03
+
+	maxBlockSize = 1 << 16
+
+	tableBits = 14
+	tableSize = 1 << tableBits
+
+	// Blocks shorter than this are not worth searching for matches
+	minNonLiteralBlockSize = 17
+)
+
+var (
+	ErrCorrupt  = errors.New("snappy: corrupt input")
+	ErrTooLarge = errors.New("snappy: decoded block is too large")
+)
+
+// Encode returns the encoded form of src
+func Encode(src []byte) []byte {
+	dst := make([]byte, 0, MaxEncodedLen(len(src)))
+	dst = appendUvarint(dst, uint64(len(src)))
+
+	for len(src) > 0 {
+		block := src
+		if len(block) > maxBlockSize {
+			block = block[:maxBlockSize]
+		}
+		src = src[len(block):]
+
+		if len(block) < minNonLiteralBlockSize {
+			dst = emitLiteral(dst, block)
+		} else {
+			dst = encodeBlock(dst, block)
+		}
+	}
+
+	return dst
+}
+
+// MaxEncodedLen returns the worst-case size of the encoded form of srcLen bytes
+func MaxEncodedLen(srcLen int) int {
+	return 32 + srcLen + srcLen/6
+}
+
+// Decode returns the decoded form of src
+func Decode(src []byte) ([]byte, error) {
+	decodedLen, n := binary.Uvarint(src)
+	if n <= 0 {
+		return nil, ErrCorrupt
+	}
+	if decodedLen > 1<<32-1 {
+		return nil, ErrTooLarge
+	}
+	src = src[n:]
+
+	dst := make([]byte, 0, decodedLen)
+
+	for len(src) > 0 {
+		var (
+			length, offset int
+			tag            = src[0]
+		)
+
+		switch tag & 0x03 {
+		case tagLiteral:
+			x := int(tag >> 2)
+			switch {
+			case x < 60:
+				src = src[1:]
+			case x < 64:
+				extraBytes := x - 59
+				if len(src) < 1+extraBytes {
+					return nil, ErrCorrupt
+				}
+				x = 0
+				for i := extraBytes; i > 0; i-- {
+					x = x<<8 | int(src[i])
+				}
+				src = src[1+extraBytes:]
+			}
+			length = x + 1
+			if length <= 0 || len(src) < length {
+				return nil, ErrCorrupt
+			}
+			dst = append(dst, src[:length]...)
+			src = src[length:]
+			continue
+
+		case tagCopy1:
+			if len(src) < 2 {
+				return nil, ErrCorrupt
+			}
+			length = 4 + int(tag>>2)&0x07
+			offset = int(tag&0xe0)<<3 | int(src[1])
+			src = src[2:]
+
+		case tagCopy2:
+			if len(src) < 3 {
+				return nil, ErrCorrupt
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src[1:3]))
+			src = src[3:]
+
+		case tagCopy4:
+			if len(src) < 5 {
+				return nil, ErrCorrupt
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src[1:5]))
+			src = src[5:]
+		}
+
+		if offset <= 0 || offset > len(dst) {
+			return nil, ErrCorrupt
+		}
+
+		// Copies may overlap with their own output, so go byte by byte
+		start := len(dst) - offset
+		for i := 0; i < length; i++ {
+			dst = append(dst, dst[start+i])
+		}
+	}
+
+	if uint64(len(dst)) != decodedLen {
+		return nil, ErrCorrupt
+	}
+
+	return dst, nil
+}
+
+func encodeBlock(dst, block []byte) []byte {
+	var (
+		table    [tableSize]int32
+		litStart = 0
+		s        = 0
+	)
+
+	for i := range table {
+		table[i] = -1
+	}
+
+	for s+4 <= len(block) {
+		var (
+			current   = binary.LittleEndian.Uint32(block[s:])
+			h         = hash(current)
+			candidate = int(table[h])
+		)
+		table[h] = int32(s)
+
+		if candidate < 0 || binary.LittleEndian.Uint32(block[candidate:]) != current {
+			s++
+			continue
+		}
+
+		dst = emitLiteral(dst, block[litStart:s])
+
+		matchStart := s
+		s, candidate = s+4, candidate+4
+		for s < len(block) && block[s] == block[candidate] {
+			s++
+			candidate++
+		}
+
+		dst = emitCopy(dst, s-candidate, s-matchStart)
+		litStart = s
+	}
+
+	return emitLiteral(dst, block[litStart:])
+}
+
+func emitLiteral(dst, literal []byte) []byte {
+	if len(literal) == 0 {
+		return dst
+	}
+
+	n := len(literal) - 1
+	switch {
+	case n < 60:
+		dst = append(dst, byte(n)<<2|tagLiteral)
+	case n < 1<<8:
+		dst = append(dst, 60<<2|tagLiteral, byte(n))
+	default:
+		dst = append(dst, 61<<2|tagLiteral, byte(n), byte(n>>8))
+	}
+
+	return append(dst, literal...)
+}
+
+func emitCopy(dst []byte, offset, length int) []byte {
+	for length >= 68 {
+		dst = append(dst, 63<<2|tagCopy2, byte(offset), byte(offset>>8))
+		length -= 64
+	}
+	if length > 64 {
+		dst = append(dst, 59<<2|tagCopy2, byte(offset), byte(offset>>8))
+		length -= 60
+	}
+	if length >= 12 || offset >= 2048 {
+		return append(dst, byte(length-1)<<2|tagCopy2, byte(offset), byte(offset>>8))
+	}
+	return append(dst, byte(offset>>8)<<5|byte(length-4)<<2|tagCopy1, byte(offset))
+}
+
+func hash(u uint32) uint32 {
+	return (u * 0x1e35a7bd) >> (32 - tableBits)
+}
+
+func appendUvarint(dst []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(dst, buf[:n]...)
+}
@@ -0,0 +1,64 @@
+// +build unit
+
+package snappy
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestSnappy_RoundTrip(t *testing.T) {
+	random := make([]byte, 200000)
+	rand.Read(random)
+
+	tests := []struct {
+		name  string
+		input []byte
+	}{
+		{name: "Empty input", input: []byte{}},
+		{name: "Short literal", input: []byte("abc")},
+		{name: "Repetitive text", input: []byte(strings.Repeat("INFO: it's a new log entry :)\n", 5000))},
+		{name: "Long run of a single byte", input: bytes.Repeat([]byte{'x'}, 100000)},
+		{name: "Incompressible data", input: random},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded := Encode(tt.input)
+			if len(encoded) > MaxEncodedLen(len(tt.input)) {
+				t.Errorf("encoded length exceeds the limit, got: %d, limit: %d",
+					len(encoded), MaxEncodedLen(len(tt.input)))
+			}
+
+			decoded, err := Decode(encoded)
+			if err != nil {
+				t.Fatalf("unexpected error on decode: %s", err)
+			}
+
+			if !bytes.Equal(decoded, tt.input) {
+				t.Errorf("decoded data doesn't match the input")
+			}
+		})
+	}
+}
+
+func TestSnappy_Compresses(t *testing.T) {
+	input := []byte(strings.Repeat("WARN: the same line again and again\n", 1000))
+
+	if encoded := Encode(input); len(encoded)*10 > len(input) {
+		t.Errorf("repetitive input is not compressed, got: %d bytes from %d", len(encoded), len(input))
+	}
+}
+
+func TestSnappy_DecodeCorrupt(t *testing.T) {
+	encoded := Encode([]byte(strings.Repeat("corrupt me ", 100)))
+
+	if _, err := Decode(encoded[:len(encoded)/2]); err == nil {
+		t.Errorf("expected error on truncated input, but not occurred")
+	}
+	if _, err := Decode(nil); err == nil {
+		t.Errorf("expected error on empty input, but not occurred")
+	}
+}
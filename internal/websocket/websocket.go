@@ -0,0 +1,287 @@
+//
+// Package websocket implements the minimal subset of WebSocket protocol, required
+// to consume Loki's tail API with the standard library only
+//	Read more at: https://tools.ietf.org/html/rfc6455
+//
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+const (
+	OpContinuation = 0x0
+	OpText         = 0x1
+	OpBinary       = 0x2
+	OpClose        = 0x8
+	OpPing         = 0x9
+	OpPong         = 0xa
+
+	// Messages bigger than that are rejected to protect from broken peers
+	maxMessageSize = 64 << 20
+
+	acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+var ErrClosed = errors.New("websocket: connection is closed")
+
+type Conn struct {
+	rw       io.ReadWriteCloser
+	reader   *bufio.Reader
+	isClient bool // Clients mask their frames, servers don't
+
+	writeMu sync.Mutex
+}
+
+//
+// Performs an opening handshake via the HTTP client (so its transport settings are applied),
+// req is a GET request with http:// or https:// URL, handshake headers are added to it
+//
+func Dial(httpClient *http.Client, req *http.Request) (*Conn, error) {
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("websocket: failed to generate key: %s", err)
+	}
+	encodedKey := base64.StdEncoding.EncodeToString(key)
+
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", encodedKey)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("websocket: handshake failed: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		defer func() { _ = resp.Body.Close() }()
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, &HandshakeError{StatusCode: resp.StatusCode, Message: string(body)}
+	}
+
+	rw, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok || resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(encodedKey) {
+		_ = resp.Body.Close()
+		return nil, errors.New("websocket: invalid handshake response")
+	}
+
+	return &Conn{
+		rw:       rw,
+		reader:   bufio.NewReader(rw),
+		isClient: true,
+	}, nil
+}
+
+//
+// Upgrades a server side HTTP connection
+//
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || r.Header.Get("Sec-WebSocket-Key") == "" {
+		http.Error(w, "websocket handshake is expected", http.StatusBadRequest)
+		return nil, errors.New("websocket: not a handshake request")
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, errors.New("websocket: response doesn't support hijacking")
+	}
+
+	conn, buffered, err := hijacker.Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("websocket: failed to hijack connection: %s", err)
+	}
+
+	_, err = fmt.Fprintf(buffered, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		acceptKey(r.Header.Get("Sec-WebSocket-Key")))
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("websocket: failed to complete handshake: %s", err)
+	}
+
+	return &Conn{
+		rw:     conn,
+		reader: buffered.Reader,
+	}, nil
+}
+
+// Returned by Dial when the server doesn't switch protocols
+type HandshakeError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *HandshakeError) Error() string {
+	return fmt.Sprintf("websocket: unexpected handshake response code [code=%d], message: %s", e.StatusCode, e.Message)
+}
+
+//
+// Returns the next text or binary message, answering pings on the way.
+// Returns ErrClosed once the peer closes the connection.
+//
+func (rcv *Conn) ReadMessage() (opcode int, payload []byte, err error) {
+	var message []byte
+	opcode = -1
+
+	for {
+		fin, frameOpcode, framePayload, err := rcv.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch frameOpcode {
+		case OpPing:
+			if err = rcv.WriteMessage(OpPong, framePayload); err != nil {
+				return 0, nil, err
+			}
+			continue
+
+		case OpPong:
+			continue
+
+		case OpClose:
+			_ = rcv.WriteMessage(OpClose, framePayload)
+			return 0, nil, ErrClosed
+
+		case OpContinuation:
+			if opcode < 0 {
+				return 0, nil, errors.New("websocket: unexpected continuation frame")
+			}
+
+		default:
+			if opcode >= 0 {
+				return 0, nil, errors.New("websocket: unfinished fragmented message")
+			}
+			opcode = frameOpcode
+		}
+
+		if len(message)+len(framePayload) > maxMessageSize {
+			return 0, nil, errors.New("websocket: message is too big")
+		}
+		message = append(message, framePayload...)
+
+		if fin {
+			return opcode, message, nil
+		}
+	}
+}
+
+func (rcv *Conn) WriteMessage(opcode int, payload []byte) error {
+	rcv.writeMu.Lock()
+	defer rcv.writeMu.Unlock()
+
+	frame := make([]byte, 0, len(payload)+14)
+	frame = append(frame, 0x80|byte(opcode))
+
+	var maskBit byte
+	if rcv.isClient {
+		maskBit = 0x80
+	}
+
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, maskBit|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, maskBit|126, 0, 0)
+		binary.BigEndian.PutUint16(frame[len(frame)-2:], uint16(len(payload)))
+	default:
+		frame = append(frame, maskBit|127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[len(frame)-8:], uint64(len(payload)))
+	}
+
+	if !rcv.isClient {
+		frame = append(frame, payload...)
+	} else {
+		mask := make([]byte, 4)
+		if _, err := rand.Read(mask); err != nil {
+			return fmt.Errorf("websocket: failed to generate mask: %s", err)
+		}
+		frame = append(frame, mask...)
+		for i := range payload {
+			frame = append(frame, payload[i]^mask[i%4])
+		}
+	}
+
+	_, err := rcv.rw.Write(frame)
+	return err
+}
+
+func (rcv *Conn) Close() error {
+	_ = rcv.WriteMessage(OpClose, []byte{0x03, 0xe8}) // 1000: normal closure
+	return rcv.rw.Close()
+}
+
+func (rcv *Conn) readFrame() (fin bool, opcode int, payload []byte, err error) {
+	header := make([]byte, 2)
+	if _, err = io.ReadFull(rcv.reader, header); err != nil {
+		return false, 0, nil, err
+	}
+
+	var (
+		isMasked = header[1]&0x80 != 0
+		length   = uint64(header[1] & 0x7f)
+	)
+
+	fin = header[0]&0x80 != 0
+	opcode = int(header[0] & 0x0f)
+
+	switch length {
+	case 126:
+		extended := make([]byte, 2)
+		if _, err = io.ReadFull(rcv.reader, extended); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended))
+	case 127:
+		extended := make([]byte, 8)
+		if _, err = io.ReadFull(rcv.reader, extended); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(extended)
+	}
+
+	if length > maxMessageSize {
+		return false, 0, nil, errors.New("websocket: frame is too big")
+	}
+
+	var mask []byte
+	if isMasked {
+		mask = make([]byte, 4)
+		if _, err = io.ReadFull(rcv.reader, mask); err != nil {
+			return false, 0, nil, err
+		}
+	}
+
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(rcv.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+
+	if isMasked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+
+	return fin, opcode, payload, nil
+}
+
+func acceptKey(key string) string {
+	hash := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
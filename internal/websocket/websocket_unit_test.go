@@ -0,0 +1,88 @@
+// +build unit
+
+package websocket
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebSocket_Echo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+
+		// Ping is answered by the client while it waits for the echo
+		_ = conn.WriteMessage(OpPing, []byte("are you there?"))
+
+		for {
+			opcode, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err = conn.WriteMessage(opcode, message); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+
+	conn, err := Dial(http.DefaultClient, req)
+	if err != nil {
+		t.Fatalf("failed to dial: %s", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	tests := []struct {
+		name    string
+		opcode  int
+		payload []byte
+	}{
+		{name: "Short text", opcode: OpText, payload: []byte(`{"streams":[]}`)},
+		{name: "Empty text", opcode: OpText, payload: []byte{}},
+		{name: "16-bit length", opcode: OpText, payload: []byte(strings.Repeat("a", 300))},
+		{name: "64-bit length", opcode: OpBinary, payload: bytes.Repeat([]byte{0xff}, 70000)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := conn.WriteMessage(tt.opcode, tt.payload); err != nil {
+				t.Fatalf("failed to write: %s", err)
+			}
+
+			opcode, payload, err := conn.ReadMessage()
+			if err != nil {
+				t.Fatalf("failed to read: %s", err)
+			}
+
+			if opcode != tt.opcode || !bytes.Equal(payload, tt.payload) {
+				t.Errorf("echo mismatch: got opcode %d and %d bytes, want opcode %d and %d bytes",
+					opcode, len(payload), tt.opcode, len(tt.payload))
+			}
+		})
+	}
+}
+
+func TestWebSocket_HandshakeRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad selector", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+
+	_, err := Dial(http.DefaultClient, req)
+
+	handshakeErr, ok := err.(*HandshakeError)
+	if !ok || handshakeErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("Dial() error = %v, want handshake error with 400 status", err)
+	}
+}
@@ -0,0 +1,193 @@
+package promtail
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+//
+// Defines what happens to a label breaking Loki's rules
+//
+type LabelPolicy uint8
+
+const (
+	// Replaces invalid characters of names with "_", truncates too long values
+	// and drops labels above the names limit
+	SanitizeLabels LabelPolicy = iota
+	// Drops invalid labels and labels above the names limit
+	DropInvalidLabels
+	// Drops the whole entry, reporting it to the error callback
+	RejectInvalidLabels
+)
+
+// Loki's defaults of max_label_names_per_series and max_label_value_length
+const (
+	defaultMaxLabelNames       = 15
+	defaultMaxLabelValueLength = 2048
+)
+
+type labelValidator struct {
+	policy         LabelPolicy
+	maxNames       int
+	maxValueLength int
+}
+
+//
+// Fixes labels in place according to the policy, returns an error only if labels
+// are invalid and the policy rejects them
+//	NOTE: names of series labels (default labels and level label) are not counted twice,
+//	tenant label is not validated, as it's never sent as a label
+//
+func (rcv *labelValidator) validate(labels, seriesLabels map[string]string, tenantLabel string) (sanitized, dropped int, err error) {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		if name != tenantLabel {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value, ok := labels[name]
+		if !ok {
+			continue
+		}
+
+		if !isValidLabelName(name) {
+			if rcv.policy == RejectInvalidLabels {
+				return sanitized, dropped, fmt.Errorf("label name %s is invalid", name)
+			}
+
+			delete(labels, name)
+
+			sanitizedName := sanitizeLabelName(name)
+			if _, exists := labels[sanitizedName]; rcv.policy == DropInvalidLabels || sanitizedName == "" || exists {
+				dropped++
+				continue
+			}
+
+			name = sanitizedName
+			labels[name] = value
+			sanitized++
+		}
+
+		if len(value) > rcv.maxValueLength || !utf8.ValidString(value) {
+			switch rcv.policy {
+			case RejectInvalidLabels:
+				return sanitized, dropped, fmt.Errorf("value of label %s is too long or not UTF-8", name)
+			case DropInvalidLabels:
+				delete(labels, name)
+				dropped++
+			default:
+				labels[name] = sanitizeLabelValue(value, rcv.maxValueLength)
+				sanitized++
+			}
+		}
+	}
+
+	// Names are sorted again, as some of them could be sanitized
+	names = names[:0]
+	for name := range labels {
+		if _, ok := seriesLabels[name]; !ok && name != tenantLabel && name != logLevelForcedLabel {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	seriesNames := len(seriesLabels) + 1
+	if _, ok := seriesLabels[logLevelForcedLabel]; ok {
+		seriesNames--
+	}
+
+	for i := range names {
+		if seriesNames+i < rcv.maxNames {
+			continue
+		}
+
+		if rcv.policy == RejectInvalidLabels {
+			return sanitized, dropped, fmt.Errorf("number of labels exceeds limit of %d", rcv.maxNames)
+		}
+
+		delete(labels, names[i])
+		dropped++
+	}
+
+	return sanitized, dropped, nil
+}
+
+//
+// Reports whether the entry's labels are fine to be sent, fixing them if the policy allows
+//
+func (rcv *promtailClient) validateLabels(labels map[string]string) bool {
+	if rcv.labelValidator == nil || len(labels) == 0 {
+		return true
+	}
+
+	sanitized, dropped, err := rcv.labelValidator.validate(labels, rcv.defaultLabels, rcv.tenantLabel)
+
+	atomic.AddUint64(&rcv.metrics.labelsSanitized, uint64(sanitized))
+	atomic.AddUint64(&rcv.metrics.labelsDropped, uint64(dropped))
+
+	if err != nil {
+		atomic.AddUint64(&rcv.metrics.entriesRejected, 1)
+		rcv.errorHandler(fmt.Errorf("entry is rejected due to invalid labels: %s", err))
+		return false
+	}
+
+	return true
+}
+
+// Label names must match [a-zA-Z_][a-zA-Z0-9_]*
+func isValidLabelName(name string) bool {
+	if name == "" {
+		return false
+	}
+
+	for i := 0; i < len(name); i++ {
+		if !isLabelNameChar(name[i], i == 0) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isLabelNameChar(c byte, isFirst bool) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!isFirst && c >= '0' && c <= '9')
+}
+
+func sanitizeLabelName(name string) string {
+	if name == "" {
+		return ""
+	}
+
+	sanitized := []byte(name)
+	for i := range sanitized {
+		if !isLabelNameChar(sanitized[i], false) {
+			sanitized[i] = '_'
+		}
+	}
+
+	if sanitized[0] >= '0' && sanitized[0] <= '9' {
+		sanitized = append([]byte{'_'}, sanitized...)
+	}
+
+	return string(sanitized)
+}
+
+func sanitizeLabelValue(value string, maxLength int) string {
+	value = strings.ToValidUTF8(value, string(utf8.RuneError))
+	if len(value) <= maxLength {
+		return value
+	}
+
+	cut := maxLength
+	for cut > 0 && !utf8.RuneStart(value[cut]) {
+		cut--
+	}
+
+	return value[:cut]
+}
@@ -0,0 +1,151 @@
+// +build unit
+
+package promtail
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_LabelValidator(t *testing.T) {
+	var (
+		seriesLabels = map[string]string{"app": "api", "instanceId": "api-1"}
+		longValue    = strings.Repeat("ж", 6)
+	)
+
+	tests := []struct {
+		name          string
+		policy        LabelPolicy
+		maxNames      int
+		labels        map[string]string
+		want          map[string]string
+		wantSanitized int
+		wantDropped   int
+		wantErr       bool
+	}{
+		{
+			name:   "Valid labels are kept",
+			policy: RejectInvalidLabels,
+			labels: map[string]string{"user_id": "42", "_internal": "yes", "app": "override", "__tenant__": "team a"},
+			want:   map[string]string{"user_id": "42", "_internal": "yes", "app": "override", "__tenant__": "team a"},
+		},
+		{
+			name:          "Sanitize names",
+			policy:        SanitizeLabels,
+			labels:        map[string]string{"http.method": "GET", "1st": "a", "user-id": "42", "user_id": "43"},
+			want:          map[string]string{"http_method": "GET", "_1st": "a", "user_id": "43"},
+			wantSanitized: 2,
+			wantDropped:   1,
+		},
+		{
+			name:          "Sanitize values",
+			policy:        SanitizeLabels,
+			labels:        map[string]string{"long": longValue, "broken": "a\xffb"},
+			want:          map[string]string{"long": "жжжжж", "broken": "a�b"},
+			wantSanitized: 2,
+		},
+		{
+			name:        "Drop invalid labels",
+			policy:      DropInvalidLabels,
+			labels:      map[string]string{"http.method": "GET", "long": longValue, "ok": "yes"},
+			want:        map[string]string{"ok": "yes"},
+			wantDropped: 2,
+		},
+		{
+			name:    "Reject invalid name",
+			policy:  RejectInvalidLabels,
+			labels:  map[string]string{"http.method": "GET"},
+			wantErr: true,
+		},
+		{
+			name:    "Reject too long value",
+			policy:  RejectInvalidLabels,
+			labels:  map[string]string{"long": longValue},
+			wantErr: true,
+		},
+		{
+			// Series labels and level label take 3 of 5 names
+			name:        "Drop labels above the names limit",
+			policy:      SanitizeLabels,
+			maxNames:    5,
+			labels:      map[string]string{"a": "1", "b": "2", "c": "3", "instanceId": "api-2", logLevelForcedLabel: "x"},
+			want:        map[string]string{"a": "1", "b": "2", "instanceId": "api-2", logLevelForcedLabel: "x"},
+			wantDropped: 1,
+		},
+		{
+			name:     "Reject labels above the names limit",
+			policy:   RejectInvalidLabels,
+			maxNames: 5,
+			labels:   map[string]string{"a": "1", "b": "2", "c": "3"},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validator := &labelValidator{policy: tt.policy, maxNames: tt.maxNames, maxValueLength: 10}
+			if validator.maxNames == 0 {
+				validator.maxNames = defaultMaxLabelNames
+			}
+
+			sanitized, dropped, err := validator.validate(tt.labels, seriesLabels, TenantLabel)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v, want error: %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if !reflect.DeepEqual(tt.labels, tt.want) {
+				t.Errorf("unexpected labels:\n got  = %v\n want = %v", tt.labels, tt.want)
+			}
+			if sanitized != tt.wantSanitized || dropped != tt.wantDropped {
+				t.Errorf("unexpected fixes, sanitized: %d, dropped: %d, want: %d, %d",
+					sanitized, dropped, tt.wantSanitized, tt.wantDropped)
+			}
+		})
+	}
+}
+
+func TestPromtailClient_LabelValidation(t *testing.T) {
+	var (
+		exchanger = &fakeExchanger{}
+		errorsNum = 0
+	)
+
+	client, err := NewClient(exchanger, map[string]string{"app": "api"},
+		WithSendBatchTimeout(time.Hour),
+		WithLabelValidation(RejectInvalidLabels, 0, 0),
+		WithErrorCallback(func(err error) { errorsNum++ }),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error on client initialization: %s", err)
+	}
+	defer client.Close()
+
+	client.LogfWithLabels(Info, map[string]string{"user.id": "42"}, "rejected")
+	client.LogfWithLabels(Info, map[string]string{"user_id": "42"}, "sent")
+	client.Flush()
+
+	if got := exchanger.countPushedEntries(); got != 1 {
+		t.Errorf("entry with invalid labels must be rejected, pushed entries: %d", got)
+	}
+	if errorsNum != 1 {
+		t.Errorf("rejected entry must be reported, reported errors: %d", errorsNum)
+	}
+	if stats := client.Stats(); stats.EntriesRejected != 1 || stats.EntriesEnqueued != 1 {
+		t.Errorf("rejected entry must not reach the queue, stats: %+v", stats)
+	}
+
+	_, err = NewClient(exchanger, map[string]string{"app-name": "api"}, WithLabelValidation(RejectInvalidLabels, 0, 0))
+	if err == nil {
+		t.Error("expected error on invalid default labels, but not occurred")
+	}
+
+	if _, err = NewClient(exchanger, nil, WithLabelValidation(LabelPolicy(42), 0, 0)); err == nil {
+		t.Error("expected error on unknown label policy, but not occurred")
+	}
+}
@@ -0,0 +1,93 @@
+package promtail
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+//
+// Parses a level name, case insensitive, "WARNING" is accepted as well
+//
+func ParseLevel(name string) (Level, error) {
+	switch strings.ToUpper(strings.TrimSpace(name)) {
+	case "DEBUG":
+		return Debug, nil
+	case "INFO":
+		return Info, nil
+	case "WARN", "WARNING":
+		return Warn, nil
+	case "ERROR":
+		return Error, nil
+	case "FATAL":
+		return Fatal, nil
+	case "PANIC":
+		return Panic, nil
+	}
+	return Debug, fmt.Errorf("unknown log level: %q", name)
+}
+
+//
+// Changes the minimum level of entries to be sent, entries of lower levels are dropped
+// before reaching the queue
+//	NOTE: safe to call concurrently with logging, unknown levels are reported to the error
+//	callback and ignored, as they would drop all entries
+//
+func (rcv *promtailClient) SetMinLevel(level Level) {
+	if level > Panic {
+		rcv.errorHandler(fmt.Errorf("unknown log level: %d, minimum level is not changed", level))
+		return
+	}
+
+	atomic.StoreUint32(&rcv.minLevel, uint32(level))
+}
+
+func (rcv *promtailClient) MinLevel() Level {
+	return Level(atomic.LoadUint32(&rcv.minLevel))
+}
+
+func (rcv *promtailClient) isLevelEnabled(level Level) bool {
+	return uint32(level) >= atomic.LoadUint32(&rcv.minLevel)
+}
+
+// Level is a name here, while it's stored as a number elsewhere (e.g. in the spool)
+type levelPayload struct {
+	Level string `json:"level"`
+}
+
+//
+// Serves the minimum level as JSON: GET returns it, PUT changes it
+//	Example: curl -X PUT -d '{"level":"debug"}' http://pod:8080/log/level
+//
+func (rcv *promtailClient) LevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+
+		case http.MethodPut:
+			var payload levelPayload
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+				http.Error(w, fmt.Sprintf("failed to decode level: %s", err), http.StatusBadRequest)
+				return
+			}
+
+			level, err := ParseLevel(payload.Level)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			rcv.SetMinLevel(level)
+
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(levelPayload{Level: rcv.MinLevel().String()})
+	})
+}
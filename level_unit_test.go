@@ -0,0 +1,151 @@
+// +build unit
+
+package promtail
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_ParseLevel(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    Level
+		wantErr bool
+	}{
+		{name: "debug", want: Debug},
+		{name: "INFO", want: Info},
+		{name: "Warning", want: Warn},
+		{name: " error ", want: Error},
+		{name: "fatal", want: Fatal},
+		{name: "panic", want: Panic},
+		{name: "verbose", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLevel(tt.name)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v, want error: %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("unexpected level, got: %s, want: %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPromtailClient_MinLevel(t *testing.T) {
+	var (
+		exchanger = &fakeExchanger{}
+		errorsNum = 0
+	)
+
+	client, err := NewClient(exchanger, nil,
+		WithSendBatchTimeout(time.Hour),
+		WithMinLevel(Info),
+		WithErrorCallback(func(err error) { errorsNum++ }),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error on client initialization: %s", err)
+	}
+	defer client.Close()
+
+	client.Debugf("dropped")
+	client.LogKV(Debug, "dropped", "key", "value")
+	client.Infof("sent")
+	client.Flush()
+
+	if got := exchanger.countPushedEntries(); got != 1 {
+		t.Errorf("entries below the minimum level must be dropped, pushed entries: %d", got)
+	}
+	if stats := client.Stats(); stats.EntriesEnqueued != 1 {
+		t.Errorf("dropped entries must not reach the queue, enqueued: %d", stats.EntriesEnqueued)
+	}
+
+	client.SetMinLevel(Debug)
+	client.Debugf("sent")
+	client.Flush()
+
+	if got := exchanger.countPushedEntries(); got != 2 {
+		t.Errorf("entries of the lowered level must be sent, pushed entries: %d", got)
+	}
+
+	client.SetMinLevel(Level(99))
+
+	if level := client.MinLevel(); level != Debug || errorsNum != 1 {
+		t.Errorf("unknown level must be reported and ignored, minimum level: %s, reported errors: %d", level, errorsNum)
+	}
+
+	if _, err = NewClient(exchanger, nil, WithMinLevel(Level(42))); err == nil {
+		t.Error("expected error on unknown minimum level, but not occurred")
+	}
+}
+
+func TestPromtailClient_LevelHandler(t *testing.T) {
+	client, err := NewClient(&fakeExchanger{}, nil, WithMinLevel(Warn))
+	if err != nil {
+		t.Fatalf("unexpected error on client initialization: %s", err)
+	}
+	defer client.Close()
+
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		wantStatus int
+		wantBody   string
+		wantLevel  Level
+	}{
+		{
+			name:       "Get",
+			method:     http.MethodGet,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"level":"WARN"}`,
+			wantLevel:  Warn,
+		},
+		{
+			name:       "Put",
+			method:     http.MethodPut,
+			body:       `{"level":"debug"}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"level":"DEBUG"}`,
+			wantLevel:  Debug,
+		},
+		{
+			name:       "Put unknown level",
+			method:     http.MethodPut,
+			body:       `{"level":"verbose"}`,
+			wantStatus: http.StatusBadRequest,
+			wantLevel:  Debug,
+		},
+		{
+			name:       "Unsupported method",
+			method:     http.MethodPost,
+			body:       `{"level":"error"}`,
+			wantStatus: http.StatusMethodNotAllowed,
+			wantLevel:  Debug,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			client.LevelHandler().ServeHTTP(recorder, httptest.NewRequest(tt.method, "/log/level", strings.NewReader(tt.body)))
+
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("unexpected status code, got: %d, want: %d", recorder.Code, tt.wantStatus)
+			}
+			if got := strings.TrimSpace(recorder.Body.String()); tt.wantBody != "" && got != tt.wantBody {
+				t.Errorf("unexpected body, got: %s, want: %s", got, tt.wantBody)
+			}
+			if got := client.MinLevel(); got != tt.wantLevel {
+				t.Errorf("unexpected minimum level, got: %s, want: %s", got, tt.wantLevel)
+			}
+		})
+	}
+}
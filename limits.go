@@ -0,0 +1,121 @@
+package promtail
+
+import (
+	"fmt"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/ic2hrmk/promtail/logfmt"
+)
+
+//
+// Defines what happens to a log line exceeding the line size limit
+//
+type OversizedLinePolicy uint8
+
+const (
+	// Cuts the line to the limit, ending it with TruncatedLineMarker
+	TruncateLine OversizedLinePolicy = iota
+	// Drops the whole entry, reporting it to the error callback
+	DropLine
+)
+
+const TruncatedLineMarker = "...(truncated)"
+
+const (
+	// Rough per-entry and per-stream cost of encoding (timestamp, quotes, brackets, etc.)
+	entryEncodingOverhead  = 32
+	streamEncodingOverhead = 16
+)
+
+//
+// Applies the line size limit, reports whether the entry with the line should be batched
+//	NOTE: the limit is applied to the line as the exchanger sends it, so the level prefix
+//	and metadata rendered by the fallback are taken from the message's budget
+//
+func (rcv *promtailClient) limitLine(level Level, line string, metadata map[string]string) (string, bool) {
+	if rcv.maxLineBytes == 0 {
+		return line, true
+	}
+
+	overhead := uint(len(level.String()) + len(": "))
+	if rcv.metadataFallback {
+		overhead += metadataFallbackBytes(metadata)
+	}
+
+	if overhead+uint(len(line)) <= rcv.maxLineBytes {
+		return line, true
+	}
+
+	// Level prefix and metadata can't be cut, so nothing is left to truncate without them
+	if rcv.oversizedLinePolicy == DropLine || overhead >= rcv.maxLineBytes {
+		atomic.AddUint64(&rcv.metrics.entriesOversized, 1)
+		rcv.errorHandler(fmt.Errorf("log line of %d bytes exceeds limit of %d bytes, entry is dropped",
+			overhead+uint(len(line)), rcv.maxLineBytes))
+		return "", false
+	}
+
+	atomic.AddUint64(&rcv.metrics.entriesTruncated, 1)
+
+	return truncateLine(line, rcv.maxLineBytes-overhead), true
+}
+
+// Size of metadata rendered into a line as logfmt, including separating spaces
+func metadataFallbackBytes(metadata map[string]string) uint {
+	var (
+		size     uint
+		rendered []byte
+	)
+
+	for key, value := range metadata {
+		rendered = logfmt.AppendPair(rendered[:0], key, value)
+		size += uint(len(rendered) + 1)
+	}
+
+	return size
+}
+
+//
+// Cuts the line to fit maxBytes with the marker, never splitting a multi-byte character
+//
+func truncateLine(line string, maxBytes uint) string {
+	if maxBytes <= uint(len(TruncatedLineMarker)) {
+		return TruncatedLineMarker[:maxBytes]
+	}
+
+	cut := int(maxBytes) - len(TruncatedLineMarker)
+	for cut > 0 && !utf8.RuneStart(line[cut]) {
+		cut--
+	}
+
+	return line[:cut] + TruncatedLineMarker
+}
+
+//
+// Estimates the size of entry in a push request, entry's message is expected to be formatted
+//
+func estimateEntryBytes(entry *LogEntry) uint {
+	size := uint(entryEncodingOverhead)
+
+	for i := range entry.Args {
+		if line, ok := entry.Args[i].(string); ok {
+			size += uint(len(line))
+		}
+	}
+
+	for key, value := range entry.Metadata {
+		size += uint(len(key) + len(value) + entryEncodingOverhead/2)
+	}
+
+	return size
+}
+
+func estimateLabelsBytes(labels map[string]string) uint {
+	size := uint(streamEncodingOverhead)
+
+	for key, value := range labels {
+		size += uint(len(key) + len(value) + 4)
+	}
+
+	return size
+}
@@ -0,0 +1,276 @@
+// +build unit
+
+package promtail
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_TruncateLine(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		maxBytes uint
+		want     string
+	}{
+		{
+			name:     "ASCII line",
+			line:     strings.Repeat("a", 30),
+			maxBytes: 20,
+			want:     "aaaaaa" + TruncatedLineMarker,
+		},
+		{
+			name:     "Multi-byte character is not split",
+			line:     "aaaaaж" + strings.Repeat("b", 20),
+			maxBytes: 20,
+			want:     "aaaaa" + TruncatedLineMarker,
+		},
+		{
+			name:     "Limit is shorter than the marker",
+			line:     strings.Repeat("a", 30),
+			maxBytes: 5,
+			want:     TruncatedLineMarker[:5],
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncateLine(tt.line, tt.maxBytes)
+
+			if got != tt.want {
+				t.Errorf("unexpected truncated line, got: %q, want: %q", got, tt.want)
+			}
+			if uint(len(got)) > tt.maxBytes {
+				t.Errorf("truncated line exceeds the limit: %d > %d", len(got), tt.maxBytes)
+			}
+		})
+	}
+}
+
+func TestPromtailClient_MaxLineBytes(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     OversizedLinePolicy
+		wantLines  []string
+		wantErrors int
+		wantStats  func(stats Stats) bool
+	}{
+		{
+			name:      "Truncate",
+			policy:    TruncateLine,
+			wantLines: []string{"short", "long-long" + TruncatedLineMarker},
+			wantStats: func(stats Stats) bool { return stats.EntriesTruncated == 1 && stats.EntriesOversized == 0 },
+		},
+		{
+			name:       "Drop",
+			policy:     DropLine,
+			wantLines:  []string{"short"},
+			wantErrors: 1,
+			wantStats:  func(stats Stats) bool { return stats.EntriesTruncated == 0 && stats.EntriesOversized == 1 },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				exchanger = &fakeExchanger{}
+				errorsNum = 0
+			)
+
+			client, err := NewClient(exchanger, nil,
+				WithSendBatchTimeout(time.Hour),
+				WithMaxLineBytes(uint(len("INFO: long-long")+len(TruncatedLineMarker)), tt.policy),
+				WithErrorCallback(func(err error) { errorsNum++ }),
+			)
+			if err != nil {
+				t.Fatalf("unexpected error on client initialization: %s", err)
+			}
+			defer client.Close()
+
+			client.Infof("short")
+			client.Infof("long-long-%s", strings.Repeat("x", 100))
+			client.Flush()
+
+			var lines []string
+			for _, streams := range exchanger.pushed {
+				for _, stream := range streams {
+					for _, entry := range stream.Entries {
+						lines = append(lines, entry.Args[0].(string))
+					}
+				}
+			}
+
+			if strings.Join(lines, "\n") != strings.Join(tt.wantLines, "\n") {
+				t.Errorf("unexpected pushed lines, got: %q, want: %q", lines, tt.wantLines)
+			}
+			if errorsNum != tt.wantErrors {
+				t.Errorf("unexpected number of reported errors, got: %d, want: %d", errorsNum, tt.wantErrors)
+			}
+			if stats := client.Stats(); !tt.wantStats(stats) {
+				t.Errorf("unexpected stats: %+v", stats)
+			}
+		})
+	}
+
+	if _, err := NewClient(&fakeExchanger{}, nil, WithMaxLineBytes(10, OversizedLinePolicy(42))); err == nil {
+		t.Error("expected error on unknown oversized line policy, but not occurred")
+	}
+}
+
+func TestPromtailClient_MaxBatchBytes(t *testing.T) {
+	const maxBatchBytes = 1024
+
+	exchanger := &fakeExchanger{}
+
+	client, err := NewClient(exchanger, map[string]string{"app": "test"},
+		WithSendBatchSize(1000),
+		WithSendBatchTimeout(time.Hour),
+		WithMaxBatchBytes(maxBatchBytes),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error on client initialization: %s", err)
+	}
+	defer client.Close()
+
+	// 5 huge entries don't fit into a single batch, while 20 small ones do
+	for i := 0; i < 5; i++ {
+		client.Errorf("%s", strings.Repeat("x", 400))
+	}
+	client.Flush()
+
+	if got := len(exchanger.pushed); got < 3 {
+		t.Errorf("huge entries must be split into several batches, batches: %d", got)
+	}
+
+	for i := range exchanger.pushed {
+		size := uint(0)
+		for _, stream := range exchanger.pushed[i] {
+			if len(stream.Entries) == 0 {
+				continue
+			}
+
+			size += estimateLabelsBytes(stream.Labels)
+			for _, entry := range stream.Entries {
+				size += estimateEntryBytes(entry)
+			}
+		}
+
+		if size > maxBatchBytes {
+			t.Errorf("batch #%d exceeds the size limit: %d bytes", i, size)
+		}
+	}
+
+	pushedBefore := len(exchanger.pushed)
+
+	for i := 0; i < 20; i++ {
+		client.Infof("ok")
+	}
+	client.Flush()
+
+	if got := len(exchanger.pushed) - pushedBefore; got != 1 {
+		t.Errorf("small entries must fit into a single batch, batches: %d", got)
+	}
+
+	if stats := client.Stats(); stats.BatchesSizeLimited == 0 {
+		t.Errorf("batches sealed by size must be counted, stats: %+v", stats)
+	}
+}
+
+func TestPromtailClient_MaxLineBytes_Overhead(t *testing.T) {
+	// With fallback, sent as: INFO: <message> user="john doe"
+	fallbackBytes := len(` user="john doe"`)
+
+	tests := []struct {
+		name        string
+		fallback    bool
+		maxBytes    int
+		message     string
+		keyvals     []interface{}
+		wantLine    string
+		wantDropped bool
+	}{
+		{
+			name:     "Level prefix is counted",
+			maxBytes: len("INFO: message"),
+			message:  "message",
+			wantLine: "message",
+		},
+		{
+			name:     "Message is truncated to fit level prefix",
+			maxBytes: len("INFO: mes" + TruncatedLineMarker),
+			message:  "message-" + strings.Repeat("x", 100),
+			wantLine: "mes" + TruncatedLineMarker,
+		},
+		{
+			name:     "Metadata is not counted without fallback",
+			maxBytes: len("INFO: message"),
+			message:  "message",
+			keyvals:  []interface{}{"user", "john doe"},
+			wantLine: "message",
+		},
+		{
+			name:     "Metadata is counted with fallback",
+			fallback: true,
+			maxBytes: len("INFO: message") + fallbackBytes,
+			message:  "message",
+			keyvals:  []interface{}{"user", "john doe"},
+			wantLine: "message",
+		},
+		{
+			name:     "Message is truncated to fit metadata",
+			fallback: true,
+			maxBytes: len("INFO: mes"+TruncatedLineMarker) + fallbackBytes,
+			message:  "message-" + strings.Repeat("x", 100),
+			keyvals:  []interface{}{"user", "john doe"},
+			wantLine: "mes" + TruncatedLineMarker,
+		},
+		{
+			name:        "Entry is dropped if metadata fills the limit",
+			fallback:    true,
+			maxBytes:    len("INFO: ") + fallbackBytes,
+			message:     "message",
+			keyvals:     []interface{}{"user", "john doe"},
+			wantDropped: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				exchanger = &fakeExchanger{}
+				errorsNum = 0
+			)
+
+			options := []clientOption{
+				WithSendBatchTimeout(time.Hour),
+				WithMaxLineBytes(uint(tt.maxBytes), TruncateLine),
+				WithErrorCallback(func(err error) { errorsNum++ }),
+			}
+			if tt.fallback {
+				options = append(options, WithStructuredMetadataFallback())
+			}
+
+			client, err := NewClient(exchanger, nil, options...)
+			if err != nil {
+				t.Fatalf("unexpected error on client initialization: %s", err)
+			}
+			defer client.Close()
+
+			client.LogKV(Info, tt.message, tt.keyvals...)
+			client.Flush()
+
+			if tt.wantDropped {
+				if lines := pushedLines(exchanger); len(lines) != 0 || errorsNum != 1 || client.Stats().EntriesOversized != 1 {
+					t.Errorf("entry must be dropped and reported, pushed lines: %q, reported errors: %d", lines, errorsNum)
+				}
+				return
+			}
+
+			if lines := pushedLines(exchanger); len(lines) != 1 || lines[0] != tt.wantLine {
+				t.Errorf("unexpected pushed lines, got: %q, want: %q", lines, tt.wantLine)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+//
+// Package logfmt renders key/value pairs in a logfmt notation: key=value key2="quoted value",
+// it's shared by the client (structured metadata fallback) and logger adapters
+//	Read more at: https://brandur.org/logfmt
+//
+package logfmt
+
+import (
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+)
+
+//
+// Appends a key/value pair to dst, separating it with a space from previous content.
+// Key characters, which would break the notation, are replaced with underscores.
+//
+func AppendPair(dst []byte, key, value string) []byte {
+	if len(dst) > 0 {
+		dst = append(dst, ' ')
+	}
+
+	dst = appendKey(dst, key)
+	dst = append(dst, '=')
+
+	if needsQuoting(value) {
+		return strconv.AppendQuote(dst, value)
+	}
+	return append(dst, value...)
+}
+
+// Renders key/value pairs, keyvals must have an even length
+func Format(keyvals ...string) string {
+	var dst []byte
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		dst = AppendPair(dst, keyvals[i], keyvals[i+1])
+	}
+	return string(dst)
+}
+
+func appendKey(dst []byte, key string) []byte {
+	if key == "" {
+		return append(dst, '_')
+	}
+
+	for _, r := range key {
+		if r <= ' ' || r == '=' || r == '"' || r == utf8.RuneError || !unicode.IsPrint(r) {
+			dst = append(dst, '_')
+		} else {
+			dst = append(dst, string(r)...)
+		}
+	}
+	return dst
+}
+
+func needsQuoting(value string) bool {
+	if value == "" {
+		return true
+	}
+
+	for _, r := range value {
+		if r <= ' ' || r == '=' || r == '"' || r == '\\' || r == utf8.RuneError || !unicode.IsPrint(r) {
+			return true
+		}
+	}
+	return false
+}
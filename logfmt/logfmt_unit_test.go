@@ -0,0 +1,47 @@
+// +build unit
+
+package logfmt
+
+import "testing"
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		keyvals []string
+		want    string
+	}{
+		{
+			name:    "Plain values",
+			keyvals: []string{"method", "GET", "status", "200"},
+			want:    "method=GET status=200",
+		},
+		{
+			name:    "Values requiring quotes",
+			keyvals: []string{"path", "/a b", "query", "x=1", "quote", `say "hi"`, "empty", ""},
+			want:    `path="/a b" query="x=1" quote="say \"hi\"" empty=""`,
+		},
+		{
+			name:    "Control characters",
+			keyvals: []string{"stack", "line 1\nline 2\ttab"},
+			want:    `stack="line 1\nline 2\ttab"`,
+		},
+		{
+			name:    "Invalid keys",
+			keyvals: []string{"user name", "john", "a=b", "c", "", "anonymous"},
+			want:    "user_name=john a_b=c _=anonymous",
+		},
+		{
+			name:    "Odd number of arguments",
+			keyvals: []string{"key", "value", "dangling"},
+			want:    "key=value",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Format(tt.keyvals...); got != tt.want {
+				t.Errorf("unexpected format:\n got  = %s\n want = %s", got, tt.want)
+			}
+		})
+	}
+}
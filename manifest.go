@@ -1,5 +1,12 @@
 package promtail
 
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+)
+
 type Level uint8
 
 const (
@@ -33,6 +40,7 @@ func (l Level) String() string {
 type Client interface {
 	Logf(level Level, format string, args ...interface{})
 	LogfWithLabels(level Level, labels map[string]string, format string, args ...interface{})
+	LogKV(level Level, msg string, keyvals ...interface{})
 
 	Debugf(format string, args ...interface{})
 	Infof(format string, args ...interface{})
@@ -41,11 +49,38 @@ type Client interface {
 	Fatalf(format string, args ...interface{})
 	Panicf(format string, args ...interface{})
 
+	LogfCtx(ctx context.Context, level Level, format string, args ...interface{})
+
+	DebugfCtx(ctx context.Context, format string, args ...interface{})
+	InfofCtx(ctx context.Context, format string, args ...interface{})
+	WarnfCtx(ctx context.Context, format string, args ...interface{})
+	ErrorfCtx(ctx context.Context, format string, args ...interface{})
+	FatalfCtx(ctx context.Context, format string, args ...interface{})
+	PanicfCtx(ctx context.Context, format string, args ...interface{})
+
+	Writer(level Level, labels map[string]string) io.Writer
+	StdLogger(level Level, labels map[string]string) *log.Logger
+
 	Ping() (*PongResponse, error)
 
+	SetMinLevel(level Level)
+	MinLevel() Level
+	LevelHandler() http.Handler
+
+	Stats() Stats
+	MetricsHandler() http.Handler
+
+	Flush()
 	Close()
 }
 
+//
+// Client, which samples prepared lines by their template, logger adapters use it if it's supported
+//
+type TemplateLogger interface {
+	LogWithTemplate(level Level, labels map[string]string, template, line string)
+}
+
 type PongResponse struct {
 	IsReady bool
 }
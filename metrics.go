@@ -0,0 +1,284 @@
+package promtail
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// Prometheus' default buckets, seconds
+	pushDurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	batchEntriesBuckets = []float64{1, 5, 10, 50, 100, 500, 1000, 5000}
+)
+
+//
+// A snapshot of client's delivery metrics
+//
+type Stats struct {
+	// Entries waiting in the queue to be batched
+	QueueLength   int
+	QueueCapacity int
+
+	EntriesEnqueued uint64
+	// Entries dropped due to queue overflow
+	EntriesDropped uint64
+	EntriesSent    uint64
+	// Entries of batches failed after all retries
+	EntriesFailed uint64
+
+	// Entries suppressed by sampling or rate limits
+	EntriesSuppressed uint64
+	// Entries rejected due to invalid labels
+	EntriesRejected uint64
+	// Entries with lines truncated or dropped due to the line size limit
+	EntriesTruncated uint64
+	EntriesOversized uint64
+
+	BatchesSent   uint64
+	BatchesFailed uint64
+	// Batches sealed due to the batch size limit in bytes
+	BatchesSizeLimited uint64
+
+	// Labels of entries fixed or dropped due to Loki's label rules
+	LabelsSanitized uint64
+	LabelsDropped   uint64
+
+	// Every Push call of the exchanger, including retries
+	PushAttempts uint64
+	PushErrors   uint64
+
+	// Duration of a single Push call, seconds
+	PushDuration HistogramStats
+	// Number of entries per pushed batch
+	BatchEntries HistogramStats
+}
+
+type HistogramStats struct {
+	Count uint64
+	Sum   float64
+	// Cumulative counts sorted by upper bound, the +Inf bucket equals to Count
+	Buckets []HistogramBucket
+}
+
+type HistogramBucket struct {
+	UpperBound float64
+	Count      uint64
+}
+
+type clientMetrics struct {
+	// Accessed atomically, kept first for 64-bit alignment
+	entriesEnqueued uint64
+	entriesSent     uint64
+	entriesFailed   uint64
+	batchesSent     uint64
+	batchesFailed   uint64
+	pushAttempts    uint64
+	pushErrors      uint64
+
+	entriesSuppressed  uint64
+	entriesRejected    uint64
+	labelsSanitized    uint64
+	labelsDropped      uint64
+	entriesTruncated   uint64
+	entriesOversized   uint64
+	batchesSizeLimited uint64
+
+	pushDuration *histogram
+	batchEntries *histogram
+}
+
+func newClientMetrics() *clientMetrics {
+	return &clientMetrics{
+		pushDuration: newHistogram(pushDurationBuckets),
+		batchEntries: newHistogram(batchEntriesBuckets),
+	}
+}
+
+func (rcv *clientMetrics) observePush(duration time.Duration, err error) {
+	atomic.AddUint64(&rcv.pushAttempts, 1)
+	if err != nil {
+		atomic.AddUint64(&rcv.pushErrors, 1)
+	}
+
+	rcv.pushDuration.observe(duration.Seconds())
+}
+
+func (rcv *clientMetrics) observeBatch(streams []*LogStream, err error) {
+	entries := countEntries(streams)
+
+	if err != nil {
+		atomic.AddUint64(&rcv.batchesFailed, 1)
+		atomic.AddUint64(&rcv.entriesFailed, entries)
+	} else {
+		atomic.AddUint64(&rcv.batchesSent, 1)
+		atomic.AddUint64(&rcv.entriesSent, entries)
+	}
+
+	rcv.batchEntries.observe(float64(entries))
+}
+
+type histogram struct {
+	mu     sync.Mutex
+	bounds []float64
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)),
+	}
+}
+
+func (rcv *histogram) observe(value float64) {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	for i := range rcv.bounds {
+		if value <= rcv.bounds[i] {
+			rcv.counts[i]++
+		}
+	}
+
+	rcv.count++
+	rcv.sum += value
+}
+
+func (rcv *histogram) snapshot() HistogramStats {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	stats := HistogramStats{
+		Count:   rcv.count,
+		Sum:     rcv.sum,
+		Buckets: make([]HistogramBucket, len(rcv.bounds)),
+	}
+
+	for i := range rcv.bounds {
+		stats.Buckets[i] = HistogramBucket{UpperBound: rcv.bounds[i], Count: rcv.counts[i]}
+	}
+
+	return stats
+}
+
+func (rcv *promtailClient) Stats() Stats {
+	return Stats{
+		QueueLength:   len(rcv.queue),
+		QueueCapacity: cap(rcv.queue),
+
+		EntriesEnqueued: atomic.LoadUint64(&rcv.metrics.entriesEnqueued),
+		EntriesDropped:  atomic.LoadUint64(&rcv.droppedEntries),
+		EntriesSent:     atomic.LoadUint64(&rcv.metrics.entriesSent),
+		EntriesFailed:   atomic.LoadUint64(&rcv.metrics.entriesFailed),
+
+		EntriesSuppressed: atomic.LoadUint64(&rcv.metrics.entriesSuppressed),
+		EntriesRejected:   atomic.LoadUint64(&rcv.metrics.entriesRejected),
+		EntriesTruncated:  atomic.LoadUint64(&rcv.metrics.entriesTruncated),
+		EntriesOversized:  atomic.LoadUint64(&rcv.metrics.entriesOversized),
+
+		BatchesSent:        atomic.LoadUint64(&rcv.metrics.batchesSent),
+		BatchesFailed:      atomic.LoadUint64(&rcv.metrics.batchesFailed),
+		BatchesSizeLimited: atomic.LoadUint64(&rcv.metrics.batchesSizeLimited),
+
+		LabelsSanitized: atomic.LoadUint64(&rcv.metrics.labelsSanitized),
+		LabelsDropped:   atomic.LoadUint64(&rcv.metrics.labelsDropped),
+
+		PushAttempts: atomic.LoadUint64(&rcv.metrics.pushAttempts),
+		PushErrors:   atomic.LoadUint64(&rcv.metrics.pushErrors),
+
+		PushDuration: rcv.metrics.pushDuration.snapshot(),
+		BatchEntries: rcv.metrics.batchEntries.snapshot(),
+	}
+}
+
+//
+// Exposes client's metrics in Prometheus text format, so they could be scraped
+// without Prometheus client library
+//	Read more at: https://prometheus.io/docs/instrumenting/exposition_formats/
+//
+func (rcv *promtailClient) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = w.Write(formatStats(rcv.Stats()))
+	})
+}
+
+func formatStats(stats Stats) []byte {
+	buf := &bytes.Buffer{}
+
+	writeMetric(buf, "promtail_client_queue_length", "gauge",
+		"Number of entries waiting in the queue.", float64(stats.QueueLength))
+	writeMetric(buf, "promtail_client_queue_capacity", "gauge",
+		"Maximum number of entries in the queue.", float64(stats.QueueCapacity))
+
+	writeMetric(buf, "promtail_client_entries_enqueued_total", "counter",
+		"Total number of entries put into the queue.", float64(stats.EntriesEnqueued))
+	writeMetric(buf, "promtail_client_entries_dropped_total", "counter",
+		"Total number of entries dropped due to queue overflow.", float64(stats.EntriesDropped))
+	writeMetric(buf, "promtail_client_entries_sent_total", "counter",
+		"Total number of entries accepted by Loki.", float64(stats.EntriesSent))
+	writeMetric(buf, "promtail_client_entries_failed_total", "counter",
+		"Total number of entries not delivered after all retries.", float64(stats.EntriesFailed))
+
+	writeMetric(buf, "promtail_client_entries_suppressed_total", "counter",
+		"Total number of entries suppressed by sampling or rate limits.", float64(stats.EntriesSuppressed))
+	writeMetric(buf, "promtail_client_entries_rejected_total", "counter",
+		"Total number of entries rejected due to invalid labels.", float64(stats.EntriesRejected))
+	writeMetric(buf, "promtail_client_entries_truncated_total", "counter",
+		"Total number of entries with lines truncated due to the line size limit.", float64(stats.EntriesTruncated))
+	writeMetric(buf, "promtail_client_entries_oversized_total", "counter",
+		"Total number of entries dropped due to the line size limit.", float64(stats.EntriesOversized))
+
+	writeMetric(buf, "promtail_client_batches_sent_total", "counter",
+		"Total number of batches accepted by Loki.", float64(stats.BatchesSent))
+	writeMetric(buf, "promtail_client_batches_failed_total", "counter",
+		"Total number of batches not delivered after all retries.", float64(stats.BatchesFailed))
+	writeMetric(buf, "promtail_client_batches_size_limited_total", "counter",
+		"Total number of batches sealed due to the batch size limit in bytes.", float64(stats.BatchesSizeLimited))
+
+	writeMetric(buf, "promtail_client_labels_sanitized_total", "counter",
+		"Total number of labels fixed due to Loki's label rules.", float64(stats.LabelsSanitized))
+	writeMetric(buf, "promtail_client_labels_dropped_total", "counter",
+		"Total number of labels dropped due to Loki's label rules.", float64(stats.LabelsDropped))
+
+	writeMetric(buf, "promtail_client_push_attempts_total", "counter",
+		"Total number of push requests, including retries.", float64(stats.PushAttempts))
+	writeMetric(buf, "promtail_client_push_errors_total", "counter",
+		"Total number of failed push requests, including retries.", float64(stats.PushErrors))
+
+	writeHistogram(buf, "promtail_client_push_duration_seconds",
+		"Duration of push requests.", stats.PushDuration)
+	writeHistogram(buf, "promtail_client_batch_entries",
+		"Number of entries per pushed batch.", stats.BatchEntries)
+
+	return buf.Bytes()
+}
+
+func writeMetric(buf *bytes.Buffer, name, kind, help string, value float64) {
+	_, _ = fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n%s %s\n",
+		name, help, name, kind, name, formatFloat(value))
+}
+
+func writeHistogram(buf *bytes.Buffer, name, help string, stats HistogramStats) {
+	_, _ = fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+
+	for i := range stats.Buckets {
+		_, _ = fmt.Fprintf(buf, "%s_bucket{le=\"%s\"} %d\n",
+			name, formatFloat(stats.Buckets[i].UpperBound), stats.Buckets[i].Count)
+	}
+
+	_, _ = fmt.Fprintf(buf, "%s_bucket{le=\"+Inf\"} %d\n", name, stats.Count)
+	_, _ = fmt.Fprintf(buf, "%s_sum %s\n", name, formatFloat(stats.Sum))
+	_, _ = fmt.Fprintf(buf, "%s_count %d\n", name, stats.Count)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,120 @@
+// +build unit
+
+package promtail
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPromtailClient_Stats(t *testing.T) {
+	exchanger := &fakeExchanger{
+		failures: []error{
+			&UnexpectedResponseError{StatusCode: http.StatusServiceUnavailable},
+			errors.New("connection refused"),
+			&UnexpectedResponseError{StatusCode: http.StatusBadRequest},
+		},
+	}
+
+	client, err := NewClient(exchanger, nil,
+		WithSendBatchSize(2),
+		WithSendBatchTimeout(time.Hour),
+		WithQueueSize(10),
+		WithRetryPolicy(time.Millisecond, time.Millisecond, 3),
+		WithErrorCallback(func(err error) {}),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error on client initialization: %s", err)
+	}
+	defer client.Close()
+
+	// First batch is retried twice and then rejected, the second one is accepted
+	for i := 0; i < 4; i++ {
+		client.Infof("entry #%d", i)
+	}
+	client.Flush()
+
+	stats := client.Stats()
+
+	expected := Stats{
+		QueueLength:     0,
+		QueueCapacity:   10,
+		EntriesEnqueued: 4,
+		EntriesSent:     2,
+		EntriesFailed:   2,
+		BatchesSent:     1,
+		BatchesFailed:   1,
+		PushAttempts:    4,
+		PushErrors:      3,
+	}
+
+	stats.PushDuration, stats.BatchEntries = HistogramStats{}, HistogramStats{}
+	if !reflect.DeepEqual(stats, expected) {
+		t.Errorf("unexpected stats:\n got: %+v\nwant: %+v", stats, expected)
+	}
+
+	batchEntries := client.Stats().BatchEntries
+	if batchEntries.Count != 2 || batchEntries.Sum != 4 {
+		t.Errorf("unexpected batch entries histogram: %+v", batchEntries)
+	}
+	for _, bucket := range batchEntries.Buckets {
+		// Both batches have 2 entries
+		expectedCount := uint64(2)
+		if bucket.UpperBound < 2 {
+			expectedCount = 0
+		}
+
+		if bucket.Count != expectedCount {
+			t.Errorf("unexpected count of bucket le=%v: %d, want: %d", bucket.UpperBound, bucket.Count, expectedCount)
+		}
+	}
+
+	if pushDuration := client.Stats().PushDuration; pushDuration.Count != 4 {
+		t.Errorf("unexpected push duration count: %d, want: 4", pushDuration.Count)
+	}
+}
+
+func TestPromtailClient_MetricsHandler(t *testing.T) {
+	client, err := NewClient(&fakeExchanger{}, nil, WithSendBatchTimeout(time.Hour))
+	if err != nil {
+		t.Fatalf("unexpected error on client initialization: %s", err)
+	}
+	defer client.Close()
+
+	client.Errorf("something went wrong")
+	client.Flush()
+
+	recorder := httptest.NewRecorder()
+	client.MetricsHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	body, _ := ioutil.ReadAll(recorder.Body)
+	exposition := string(body)
+
+	expectedLines := []string{
+		"# TYPE promtail_client_queue_capacity gauge",
+		"promtail_client_queue_capacity 1024",
+		"# TYPE promtail_client_entries_sent_total counter",
+		"promtail_client_entries_sent_total 1",
+		"# TYPE promtail_client_push_duration_seconds histogram",
+		`promtail_client_push_duration_seconds_bucket{le="+Inf"} 1`,
+		"promtail_client_push_duration_seconds_count 1",
+		`promtail_client_batch_entries_bucket{le="1"} 1`,
+		"promtail_client_batch_entries_sum 1",
+	}
+
+	for _, line := range expectedLines {
+		if !strings.Contains(exposition, line+"\n") {
+			t.Errorf("exposition doesn't contain line %q:\n%s", line, exposition)
+		}
+	}
+
+	if contentType := recorder.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain") {
+		t.Errorf("unexpected content type: %s", contentType)
+	}
+}
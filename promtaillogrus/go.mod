@@ -0,0 +1,13 @@
+module github.com/ic2hrmk/promtail/promtaillogrus
+
+go 1.21
+
+require (
+	github.com/ic2hrmk/promtail v0.0.0
+	github.com/sirupsen/logrus v1.9.3
+)
+
+require golang.org/x/sys v0.0.0-20220715151400-c0bba94af5f8 // indirect
+
+// Until the client is tagged, it is taken from the parent directory
+replace github.com/ic2hrmk/promtail => ../
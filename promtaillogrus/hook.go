@@ -0,0 +1,141 @@
+//
+// Package promtaillogrus provides a logrus.Hook which ships entries to Loki
+// through the batching promtail client
+//
+package promtaillogrus
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/ic2hrmk/promtail"
+	"github.com/ic2hrmk/promtail/logfmt"
+	"github.com/sirupsen/logrus"
+)
+
+//
+// Hook renders an entry's message and data as a logfmt line and passes it
+// to promtail.Client, data keys selected with WithLabelKeys() become stream labels
+//
+type Hook struct {
+	client    promtail.Client
+	levels    []logrus.Level
+	labelKeys map[string]struct{}
+}
+
+type hookOption func(h *Hook)
+
+//
+// Creates a hook, which forwards entries of all levels into the client's queue
+//	NOTE: options are applied in the order they are passed
+//
+func NewHook(client promtail.Client, options ...hookOption) *Hook {
+	h := &Hook{
+		client:    client,
+		levels:    logrus.AllLevels,
+		labelKeys: make(map[string]struct{}),
+	}
+
+	for i := range options {
+		options[i](h)
+	}
+
+	return h
+}
+
+// Data with given keys become stream labels instead of being rendered into the line
+func WithLabelKeys(keys ...string) hookOption {
+	return func(h *Hook) {
+		for i := range keys {
+			h.labelKeys[keys[i]] = struct{}{}
+		}
+	}
+}
+
+// Restricts levels of entries forwarded by the hook
+func WithLevels(levels ...logrus.Level) hookOption {
+	return func(h *Hook) {
+		h.levels = levels
+	}
+}
+
+func (h *Hook) Levels() []logrus.Level {
+	return h.levels
+}
+
+func (h *Hook) Fire(entry *logrus.Entry) error {
+	var (
+		labels map[string]string
+		keys   = make([]string, 0, len(entry.Data))
+	)
+
+	for key, value := range entry.Data {
+		if _, isLabel := h.labelKeys[key]; isLabel {
+			if labels == nil {
+				labels = make(map[string]string)
+			}
+			labels[key] = fmt.Sprint(value)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	if entry.Context != nil {
+		if contextLabels := promtail.LabelsFromContext(entry.Context); len(contextLabels) > 0 {
+			for key, value := range labels {
+				contextLabels[key] = value
+			}
+			labels = contextLabels
+		}
+	}
+
+	line := make([]byte, 0, len(entry.Message)+64)
+	line = append(line, entry.Message...)
+
+	if entry.HasCaller() {
+		line = logfmt.AppendPair(line, "caller", entry.Caller.File+":"+strconv.Itoa(entry.Caller.Line))
+	}
+	for i := range keys {
+		line = logfmt.AppendPair(line, keys[i], fmt.Sprint(entry.Data[keys[i]]))
+	}
+
+	logLine(h.client, mapLevel(entry.Level), labels, entry.Message, string(line))
+
+	// logrus exits or panics right after hooks are fired, so don't keep the entry in a batch
+	if entry.Level <= logrus.FatalLevel {
+		h.client.Flush()
+	}
+
+	return nil
+}
+
+func mapLevel(level logrus.Level) promtail.Level {
+	switch level {
+	case logrus.PanicLevel:
+		return promtail.Panic
+	case logrus.FatalLevel:
+		return promtail.Fatal
+	case logrus.ErrorLevel:
+		return promtail.Error
+	case logrus.WarnLevel:
+		return promtail.Warn
+	case logrus.InfoLevel:
+		return promtail.Info
+	default: // Debug and Trace
+		return promtail.Debug
+	}
+}
+
+//
+// Logs the line, sampled by the message without fields, if the client supports it
+//
+func logLine(client promtail.Client, level promtail.Level, labels map[string]string, message, line string) {
+	if templateLogger, ok := client.(promtail.TemplateLogger); ok {
+		templateLogger.LogWithTemplate(level, labels, message, line)
+		return
+	}
+
+	client.LogfWithLabels(level, labels, "%s", line)
+}
@@ -0,0 +1,153 @@
+// +build unit
+
+package promtaillogrus
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/ic2hrmk/promtail"
+	"github.com/sirupsen/logrus"
+)
+
+type loggedEntry struct {
+	level  promtail.Level
+	labels map[string]string
+	line   string
+}
+
+// Client which records log calls instead of sending them
+type fakeClient struct {
+	promtail.Client
+	entries []loggedEntry
+	flushes int
+}
+
+func (rcv *fakeClient) LogfWithLabels(level promtail.Level, labels map[string]string, format string, args ...interface{}) {
+	rcv.entries = append(rcv.entries, loggedEntry{
+		level:  level,
+		labels: labels,
+		line:   args[0].(string),
+	})
+}
+
+func (rcv *fakeClient) Flush() {
+	rcv.flushes++
+}
+
+func TestHook(t *testing.T) {
+	var (
+		client = &fakeClient{}
+		logger = logrus.New()
+		ctx    = promtail.ContextWithLabels(context.Background(), map[string]string{
+			"requestId": "req-1",
+			"service":   "overridden",
+		})
+	)
+
+	logger.SetOutput(ioutil.Discard)
+	logger.SetLevel(logrus.TraceLevel)
+	logger.AddHook(NewHook(client, WithLabelKeys("service")))
+
+	logger.
+		WithContext(ctx).
+		WithFields(logrus.Fields{
+			"service": "billing",
+			"amount":  42,
+			"path":    "/pay now",
+		}).
+		WithError(errors.New("card expired")).
+		Warn("payment declined")
+
+	logger.Trace("tracing")
+
+	func() {
+		defer func() { _ = recover() }()
+		logger.Panic("unrecoverable")
+	}()
+
+	want := []loggedEntry{
+		{
+			level: promtail.Warn,
+			labels: map[string]string{
+				"requestId": "req-1",
+				"service":   "billing",
+			},
+			line: `payment declined amount=42 error="card expired" path="/pay now"`,
+		},
+		{
+			level: promtail.Debug,
+			line:  "tracing",
+		},
+		{
+			level: promtail.Panic,
+			line:  "unrecoverable",
+		},
+	}
+
+	if !reflect.DeepEqual(client.entries, want) {
+		t.Errorf("unexpected entries:\n got  = %+v\n want = %+v", client.entries, want)
+	}
+
+	if client.flushes != 1 {
+		t.Errorf("panic entry must be flushed immediately, flushes: %d", client.flushes)
+	}
+}
+
+func TestMapLevel(t *testing.T) {
+	tests := []struct {
+		level logrus.Level
+		want  promtail.Level
+	}{
+		{level: logrus.TraceLevel, want: promtail.Debug},
+		{level: logrus.DebugLevel, want: promtail.Debug},
+		{level: logrus.InfoLevel, want: promtail.Info},
+		{level: logrus.WarnLevel, want: promtail.Warn},
+		{level: logrus.ErrorLevel, want: promtail.Error},
+		{level: logrus.FatalLevel, want: promtail.Fatal},
+		{level: logrus.PanicLevel, want: promtail.Panic},
+	}
+
+	for _, tt := range tests {
+		if got := mapLevel(tt.level); got != tt.want {
+			t.Errorf("unexpected mapping of %s, got: %s, want: %s", tt.level, got, tt.want)
+		}
+	}
+}
+
+// Client which records templates of prepared lines as well
+type fakeTemplateClient struct {
+	fakeClient
+	templates []string
+}
+
+func (rcv *fakeTemplateClient) LogWithTemplate(level promtail.Level, labels map[string]string, template, line string) {
+	rcv.templates = append(rcv.templates, template)
+	rcv.LogfWithLabels(level, labels, "%s", line)
+}
+
+func TestHook_Template(t *testing.T) {
+	client := &fakeTemplateClient{}
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	logger.AddHook(NewHook(client))
+
+	logger.WithField("amount", 42).Info("payment declined")
+	logger.WithField("amount", 43).Info("payment declined")
+
+	wantLines := []string{"payment declined amount=42", "payment declined amount=43"}
+	for i := range client.entries {
+		if i >= len(wantLines) || client.entries[i].line != wantLines[i] {
+			t.Errorf("unexpected lines: %+v, want: %q", client.entries, wantLines)
+			break
+		}
+	}
+
+	// Lines with different fields are sampled as one message
+	if want := []string{"payment declined", "payment declined"}; !reflect.DeepEqual(client.templates, want) {
+		t.Errorf("unexpected templates: %q, want: %q", client.templates, want)
+	}
+}
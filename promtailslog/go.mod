@@ -0,0 +1,8 @@
+module github.com/ic2hrmk/promtail/promtailslog
+
+go 1.21
+
+require github.com/ic2hrmk/promtail v0.0.0
+
+// Until the client is tagged, it is taken from the parent directory
+replace github.com/ic2hrmk/promtail => ../
@@ -0,0 +1,227 @@
+//
+// Package promtailslog provides a log/slog Handler which ships records to Loki
+// through the batching promtail client
+//
+package promtailslog
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/ic2hrmk/promtail"
+	"github.com/ic2hrmk/promtail/logfmt"
+)
+
+// Custom slog level for fatal records, mapped onto promtail.Fatal
+const LevelFatal = slog.LevelError + 4
+
+type HandlerOptions struct {
+	// Minimum level of records to handle, slog.LevelInfo by default
+	Level slog.Leveler
+
+	// Keys of attributes which become stream labels instead of being rendered into the line.
+	// Attributes inside groups are addressed with dot-separated keys, e.g. "request.method"
+	LabelKeys []string
+}
+
+//
+// Handler renders a record's message and attributes as a logfmt line and passes it
+// to promtail.Client, attributes listed in HandlerOptions.LabelKeys become stream labels
+//
+type Handler struct {
+	client    promtail.Client
+	level     slog.Leveler
+	labelKeys map[string]struct{}
+
+	// Pre-rendered state of WithAttrs and WithGroup calls
+	labels      map[string]string
+	attrs       []byte
+	groupPrefix string
+}
+
+func NewHandler(client promtail.Client, opts *HandlerOptions) *Handler {
+	if opts == nil {
+		opts = &HandlerOptions{}
+	}
+
+	h := &Handler{
+		client:    client,
+		level:     opts.Level,
+		labelKeys: make(map[string]struct{}, len(opts.LabelKeys)),
+	}
+
+	if h.level == nil {
+		h.level = slog.LevelInfo
+	}
+
+	for i := range opts.LabelKeys {
+		h.labelKeys[opts.LabelKeys[i]] = struct{}{}
+	}
+
+	return h
+}
+
+func (h *Handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *Handler) Handle(ctx context.Context, record slog.Record) error {
+	var (
+		labels = h.labels
+		line   = make([]byte, 0, len(record.Message)+len(h.attrs)+64)
+	)
+
+	line = append(line, record.Message...)
+	if len(h.attrs) > 0 {
+		line = append(line, ' ')
+		line = append(line, h.attrs...)
+	}
+
+	if record.NumAttrs() > 0 {
+		recordLabels := make(map[string]string)
+
+		record.Attrs(func(attr slog.Attr) bool {
+			line = h.appendAttr(line, recordLabels, h.groupPrefix, attr)
+			return true
+		})
+
+		if len(recordLabels) > 0 {
+			labels = mergeLabels(labels, recordLabels)
+		}
+	}
+
+	if contextLabels := promtail.LabelsFromContext(ctx); len(contextLabels) > 0 {
+		labels = mergeLabels(contextLabels, labels)
+	}
+
+	logLine(h.client, mapLevel(record.Level), labels, record.Message, string(line))
+
+	return nil
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+
+	var (
+		clone  = h.clone()
+		labels = make(map[string]string)
+	)
+
+	for i := range attrs {
+		clone.attrs = h.appendAttr(clone.attrs, labels, h.groupPrefix, attrs[i])
+	}
+
+	if len(labels) > 0 {
+		clone.labels = mergeLabels(h.labels, labels)
+	}
+
+	return clone
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	clone := h.clone()
+	clone.groupPrefix = h.groupPrefix + name + "."
+
+	return clone
+}
+
+func (h *Handler) clone() *Handler {
+	clone := *h
+	clone.attrs = append(make([]byte, 0, len(h.attrs)), h.attrs...)
+	return &clone
+}
+
+//
+// Renders an attribute into the line or promotes it to labels, groups are flattened
+// into dot-separated keys
+//
+func (h *Handler) appendAttr(line []byte, labels map[string]string, prefix string, attr slog.Attr) []byte {
+	attr.Value = attr.Value.Resolve()
+
+	if attr.Equal(slog.Attr{}) {
+		return line
+	}
+
+	if attr.Value.Kind() == slog.KindGroup {
+		if attr.Key != "" {
+			prefix += attr.Key + "."
+		}
+		for _, groupAttr := range attr.Value.Group() {
+			line = h.appendAttr(line, labels, prefix, groupAttr)
+		}
+		return line
+	}
+
+	if attr.Key == "" {
+		return line
+	}
+
+	var (
+		key   = prefix + attr.Key
+		value = formatValue(attr.Value)
+	)
+
+	if _, isLabel := h.labelKeys[key]; isLabel {
+		labels[labelName(key)] = value
+		return line
+	}
+
+	return logfmt.AppendPair(line, key, value)
+}
+
+func formatValue(value slog.Value) string {
+	if value.Kind() == slog.KindTime {
+		return value.Time().Format(time.RFC3339Nano)
+	}
+	return value.String()
+}
+
+// Loki doesn't accept dots in label names
+func labelName(key string) string {
+	return strings.ReplaceAll(key, ".", "_")
+}
+
+func mapLevel(level slog.Level) promtail.Level {
+	switch {
+	case level < slog.LevelInfo:
+		return promtail.Debug
+	case level < slog.LevelWarn:
+		return promtail.Info
+	case level < slog.LevelError:
+		return promtail.Warn
+	case level < LevelFatal:
+		return promtail.Error
+	default:
+		return promtail.Fatal
+	}
+}
+
+func mergeLabels(srcs ...map[string]string) map[string]string {
+	dst := make(map[string]string)
+	for i := range srcs {
+		for key, value := range srcs[i] {
+			dst[key] = value
+		}
+	}
+	return dst
+}
+
+//
+// Logs the line, sampled by the message without attributes, if the client supports it
+//
+func logLine(client promtail.Client, level promtail.Level, labels map[string]string, message, line string) {
+	if templateLogger, ok := client.(promtail.TemplateLogger); ok {
+		templateLogger.LogWithTemplate(level, labels, message, line)
+		return
+	}
+
+	client.LogfWithLabels(level, labels, "%s", line)
+}
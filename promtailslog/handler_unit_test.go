@@ -0,0 +1,146 @@
+// +build unit
+
+package promtailslog
+
+import (
+	"context"
+	"log/slog"
+	"reflect"
+	"testing"
+
+	"github.com/ic2hrmk/promtail"
+)
+
+type loggedEntry struct {
+	level  promtail.Level
+	labels map[string]string
+	line   string
+}
+
+// Client which records log calls instead of sending them
+type fakeClient struct {
+	promtail.Client
+	entries []loggedEntry
+}
+
+func (rcv *fakeClient) LogfWithLabels(level promtail.Level, labels map[string]string, format string, args ...interface{}) {
+	rcv.entries = append(rcv.entries, loggedEntry{
+		level:  level,
+		labels: labels,
+		line:   args[0].(string),
+	})
+}
+
+func TestHandler(t *testing.T) {
+	var (
+		client = &fakeClient{}
+		logger = slog.New(NewHandler(client, &HandlerOptions{
+			Level:     slog.LevelDebug,
+			LabelKeys: []string{"service", "request.method"},
+		}))
+		ctx = promtail.ContextWithLabels(context.Background(), map[string]string{
+			"requestId": "req-1",
+			"service":   "overridden",
+		})
+	)
+
+	logger.
+		With("service", "billing", "version", "1.2.3").
+		WithGroup("request").
+		With("method", "POST").
+		WarnContext(ctx, "payment declined",
+			"path", "/pay now",
+			slog.Group("card", "brand", "visa"),
+		)
+
+	logger.Debug("plain debug")
+	logger.Log(context.Background(), LevelFatal, "about to crash")
+
+	want := []loggedEntry{
+		{
+			level: promtail.Warn,
+			labels: map[string]string{
+				"requestId":      "req-1",
+				"service":        "billing",
+				"request_method": "POST",
+			},
+			line: `payment declined version=1.2.3 request.path="/pay now" request.card.brand=visa`,
+		},
+		{
+			level: promtail.Debug,
+			line:  "plain debug",
+		},
+		{
+			level: promtail.Fatal,
+			line:  "about to crash",
+		},
+	}
+
+	if !reflect.DeepEqual(client.entries, want) {
+		t.Errorf("unexpected entries:\n got  = %+v\n want = %+v", client.entries, want)
+	}
+}
+
+func TestHandler_Enabled(t *testing.T) {
+	handler := NewHandler(&fakeClient{}, nil)
+
+	if handler.Enabled(context.Background(), slog.LevelDebug) {
+		t.Errorf("debug must be disabled by default")
+	}
+	if !handler.Enabled(context.Background(), slog.LevelInfo) {
+		t.Errorf("info must be enabled by default")
+	}
+}
+
+func TestMapLevel(t *testing.T) {
+	tests := []struct {
+		level slog.Level
+		want  promtail.Level
+	}{
+		{level: slog.LevelDebug - 4, want: promtail.Debug},
+		{level: slog.LevelDebug, want: promtail.Debug},
+		{level: slog.LevelInfo, want: promtail.Info},
+		{level: slog.LevelInfo + 1, want: promtail.Info},
+		{level: slog.LevelWarn, want: promtail.Warn},
+		{level: slog.LevelError, want: promtail.Error},
+		{level: LevelFatal, want: promtail.Fatal},
+	}
+
+	for _, tt := range tests {
+		if got := mapLevel(tt.level); got != tt.want {
+			t.Errorf("unexpected mapping of %s, got: %s, want: %s", tt.level, got, tt.want)
+		}
+	}
+}
+
+// Client which records templates of prepared lines as well
+type fakeTemplateClient struct {
+	fakeClient
+	templates []string
+}
+
+func (rcv *fakeTemplateClient) LogWithTemplate(level promtail.Level, labels map[string]string, template, line string) {
+	rcv.templates = append(rcv.templates, template)
+	rcv.LogfWithLabels(level, labels, "%s", line)
+}
+
+func TestHandler_Template(t *testing.T) {
+	client := &fakeTemplateClient{}
+	logger := slog.New(NewHandler(client, nil))
+
+	logger.Info("payment declined", "amount", 42)
+	logger.Info("payment declined", "amount", 43)
+
+	wantLines := []string{"payment declined amount=42", "payment declined amount=43"}
+	for i := range client.entries {
+		if i >= len(wantLines) || client.entries[i].line != wantLines[i] {
+			t.Errorf("unexpected lines: %+v, want: %q", client.entries, wantLines)
+			break
+		}
+	}
+
+	// Lines with different attributes are sampled as one message
+	if want := []string{"payment declined", "payment declined"}; !reflect.DeepEqual(client.templates, want) {
+		t.Errorf("unexpected templates: %q, want: %q", client.templates, want)
+	}
+}
@@ -0,0 +1,543 @@
+//
+// Package promtailtest provides an in-process fake of Loki for tests, so clients can be
+// tested without a real Loki instance (e.g. started with docker-compose).
+//
+// The fake serves:
+//	- GET /ready
+//	- POST /loki/api/v1/push (JSON and snappy-compressed protobuf)
+//	- GET /loki/api/v1/query and /loki/api/v1/query_range (log queries with a minimal LogQL subset)
+//
+package promtailtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ic2hrmk/promtail/internal/logproto"
+	"github.com/ic2hrmk/promtail/internal/snappy"
+)
+
+type Endpoint string
+
+const (
+	EndpointReady Endpoint = "/ready"
+	EndpointPush  Endpoint = "/loki/api/v1/push"
+	// Covers both instant and range queries
+	EndpointQuery Endpoint = "/loki/api/v1/query_range"
+
+	tenantHeader = "X-Scope-OrgID"
+
+	defaultWaitTimeout = 5 * time.Second
+	defaultQueryLimit  = 100
+)
+
+type Stream struct {
+	Labels  map[string]string
+	Entries []Entry
+
+	// Received via X-Scope-OrgID header, empty for single tenant pushes
+	TenantID string
+}
+
+type Entry struct {
+	Timestamp time.Time
+	Line      string
+	Metadata  map[string]string
+}
+
+//
+// Describes how the fake answers a single request, zero value serves the request as usual
+//
+type Failure struct {
+	// Delays the response (applied before any other behaviour)
+	Latency time.Duration
+	// Responds with the status code instead of serving the request
+	StatusCode int
+	// Closes the connection without any response
+	DropConnection bool
+}
+
+// The subset of testing.TB used by assertion helpers
+type TestingT interface {
+	Helper()
+	Fatalf(format string, args ...interface{})
+}
+
+type Server struct {
+	// Base address of the fake, to be passed into clients
+	URL string
+
+	server *httptest.Server
+
+	mu          sync.Mutex
+	streams     []*Stream
+	index       map[string]*Stream
+	failures    map[Endpoint][]Failure
+	requests    map[Endpoint]int
+	waitTimeout time.Duration
+	// Closed on every push to wake up waiting assertions
+	pushed chan struct{}
+}
+
+// Starts a fake Loki server, it must be closed by the caller
+func NewServer() *Server {
+	rcv := &Server{
+		index:       make(map[string]*Stream),
+		failures:    make(map[Endpoint][]Failure),
+		requests:    make(map[Endpoint]int),
+		waitTimeout: defaultWaitTimeout,
+		pushed:      make(chan struct{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(string(EndpointReady), rcv.withFailures(EndpointReady, rcv.handleReady))
+	mux.HandleFunc(string(EndpointPush), rcv.withFailures(EndpointPush, rcv.handlePush))
+	mux.HandleFunc("/loki/api/v1/query", rcv.withFailures(EndpointQuery, rcv.handleQuery))
+	mux.HandleFunc(string(EndpointQuery), rcv.withFailures(EndpointQuery, rcv.handleQuery))
+
+	rcv.server = httptest.NewServer(mux)
+	rcv.URL = rcv.server.URL
+
+	return rcv
+}
+
+func (rcv *Server) Close() {
+	rcv.server.Close()
+}
+
+//
+// Scripts answers of the next requests to the endpoint, one failure per request in order
+//	Example: InjectFailures(EndpointPush, Failure{StatusCode: 503}, Failure{DropConnection: true})
+//
+func (rcv *Server) InjectFailures(endpoint Endpoint, failures ...Failure) {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	rcv.failures[endpoint] = append(rcv.failures[endpoint], failures...)
+}
+
+// Returns the number of requests received by the endpoint, including failed ones
+func (rcv *Server) Requests(endpoint Endpoint) int {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	return rcv.requests[endpoint]
+}
+
+// Sets how long assertion helpers wait for entries to arrive (5s by default)
+func (rcv *Server) SetWaitTimeout(timeout time.Duration) {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	rcv.waitTimeout = timeout
+}
+
+// Returns a copy of received streams, entries of the same tenant and label set are merged
+func (rcv *Server) Streams() []Stream {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	streams := make([]Stream, 0, len(rcv.streams))
+	for _, stream := range rcv.streams {
+		streams = append(streams, Stream{
+			Labels:   stream.Labels,
+			Entries:  append([]Entry(nil), stream.Entries...),
+			TenantID: stream.TenantID,
+		})
+	}
+
+	return streams
+}
+
+// Returns received entries matching the selector (see Selector for the supported syntax)
+func (rcv *Server) Entries(selector string) ([]Entry, error) {
+	parsedSelector, err := ParseSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	return rcv.selectEntries(parsedSelector), nil
+}
+
+// Forgets received streams, scripted failures and request counters
+func (rcv *Server) Reset() {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	rcv.streams = nil
+	rcv.index = make(map[string]*Stream)
+	rcv.failures = make(map[Endpoint][]Failure)
+	rcv.requests = make(map[Endpoint]int)
+}
+
+//
+// Fails the test unless an entry of a stream matching the selector, containing the substring,
+// is received within the wait timeout. Returns the first matching entry.
+//
+func (rcv *Server) RequireEntry(t TestingT, selector, substring string) Entry {
+	t.Helper()
+
+	parsedSelector, err := ParseSelector(selector)
+	if err != nil {
+		t.Fatalf("invalid selector: %s", err)
+		return Entry{}
+	}
+
+	rcv.mu.Lock()
+	deadline := time.After(rcv.waitTimeout)
+	rcv.mu.Unlock()
+
+	for {
+		rcv.mu.Lock()
+		var (
+			entries = rcv.selectEntries(parsedSelector)
+			pushed  = rcv.pushed
+		)
+		rcv.mu.Unlock()
+
+		for i := range entries {
+			if strings.Contains(entries[i].Line, substring) {
+				return entries[i]
+			}
+		}
+
+		select {
+		case <-pushed:
+		case <-deadline:
+			t.Fatalf("no entry of %s containing %q is received, matched entries: %d",
+				selector, substring, len(entries))
+			return Entry{}
+		}
+	}
+}
+
+func (rcv *Server) selectEntries(selector *Selector) []Entry {
+	var entries []Entry
+
+	for _, stream := range rcv.streams {
+		if !selector.MatchLabels(stream.Labels) {
+			continue
+		}
+
+		for i := range stream.Entries {
+			if selector.MatchLine(stream.Entries[i].Line) {
+				entries = append(entries, stream.Entries[i])
+			}
+		}
+	}
+
+	return entries
+}
+
+func (rcv *Server) withFailures(endpoint Endpoint, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rcv.mu.Lock()
+		rcv.requests[endpoint]++
+
+		var failure Failure
+		if len(rcv.failures[endpoint]) > 0 {
+			failure = rcv.failures[endpoint][0]
+			rcv.failures[endpoint] = rcv.failures[endpoint][1:]
+		}
+		rcv.mu.Unlock()
+
+		if failure.Latency > 0 {
+			select {
+			case <-time.After(failure.Latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		switch {
+		case failure.DropConnection:
+			if hijacker, ok := w.(http.Hijacker); ok {
+				if conn, _, err := hijacker.Hijack(); err == nil {
+					_ = conn.Close()
+					return
+				}
+			}
+			panic(http.ErrAbortHandler)
+
+		case failure.StatusCode != 0:
+			http.Error(w, "injected failure", failure.StatusCode)
+
+		default:
+			handler(w, r)
+		}
+	}
+}
+
+func (rcv *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	_, _ = fmt.Fprintln(w, "ready")
+}
+
+//
+// Push
+//
+
+type (
+	pushRequestDTO struct {
+		Streams []struct {
+			Stream map[string]string   `json:"stream"`
+			Values [][]json.RawMessage `json:"values"`
+		} `json:"streams"`
+	}
+)
+
+func (rcv *Server) handlePush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method is not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var streams []*Stream
+	if r.Header.Get("Content-Type") == "application/x-protobuf" {
+		streams, err = decodeProtoPush(body)
+	} else {
+		streams, err = decodeJSONPush(body)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tenantID := r.Header.Get(tenantHeader)
+
+	rcv.mu.Lock()
+	for _, stream := range streams {
+		key := tenantID + logproto.FormatLabels(stream.Labels)
+
+		recorded, ok := rcv.index[key]
+		if !ok {
+			recorded = &Stream{Labels: stream.Labels, TenantID: tenantID}
+			rcv.index[key] = recorded
+			rcv.streams = append(rcv.streams, recorded)
+		}
+
+		recorded.Entries = append(recorded.Entries, stream.Entries...)
+	}
+	close(rcv.pushed)
+	rcv.pushed = make(chan struct{})
+	rcv.mu.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func decodeJSONPush(body []byte) ([]*Stream, error) {
+	pushRequest := &pushRequestDTO{}
+	if err := json.Unmarshal(body, pushRequest); err != nil {
+		return nil, fmt.Errorf("failed to decode push request: %s", err)
+	}
+
+	streams := make([]*Stream, 0, len(pushRequest.Streams))
+	for _, dto := range pushRequest.Streams {
+		stream := &Stream{Labels: dto.Stream}
+
+		for _, value := range dto.Values {
+			if len(value) < 2 || len(value) > 3 {
+				return nil, fmt.Errorf("value must consist of 2 or 3 elements, got: %d", len(value))
+			}
+
+			var (
+				rawTimestamp string
+				entry        Entry
+			)
+
+			if err := json.Unmarshal(value[0], &rawTimestamp); err != nil {
+				return nil, fmt.Errorf("invalid timestamp: %s", err)
+			}
+			timestamp, err := strconv.ParseInt(rawTimestamp, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid timestamp: %s", err)
+			}
+			entry.Timestamp = time.Unix(0, timestamp)
+
+			if err = json.Unmarshal(value[1], &entry.Line); err != nil {
+				return nil, fmt.Errorf("invalid line: %s", err)
+			}
+			if len(value) == 3 {
+				if err = json.Unmarshal(value[2], &entry.Metadata); err != nil {
+					return nil, fmt.Errorf("invalid structured metadata: %s", err)
+				}
+			}
+
+			stream.Entries = append(stream.Entries, entry)
+		}
+
+		streams = append(streams, stream)
+	}
+
+	return streams, nil
+}
+
+func decodeProtoPush(body []byte) ([]*Stream, error) {
+	decoded, err := snappy.Decode(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress push request: %s", err)
+	}
+
+	pushRequest, err := logproto.Unmarshal(decoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode push request: %s", err)
+	}
+
+	streams := make([]*Stream, 0, len(pushRequest.Streams))
+	for i := range pushRequest.Streams {
+		labels, err := logproto.ParseLabels(pushRequest.Streams[i].Labels)
+		if err != nil {
+			return nil, err
+		}
+
+		stream := &Stream{Labels: labels}
+		for _, entry := range pushRequest.Streams[i].Entries {
+			stream.Entries = append(stream.Entries, Entry{
+				Timestamp: entry.Timestamp,
+				Line:      entry.Line,
+				Metadata:  logproto.ToLabelsMap(entry.StructuredMetadata),
+			})
+		}
+
+		streams = append(streams, stream)
+	}
+
+	return streams, nil
+}
+
+//
+// Query
+//
+
+type (
+	queryResponseDTO struct {
+		Status string `json:"status"`
+		Data   struct {
+			ResultType string            `json:"resultType"`
+			Result     []*queryStreamDTO `json:"result"`
+		} `json:"data"`
+	}
+
+	queryStreamDTO struct {
+		Stream map[string]string `json:"stream"`
+		Values [][]interface{}   `json:"values"`
+	}
+)
+
+//
+// Serves log queries over received entries: entries from [start, end) are sorted
+// in the direction and cut by limit. Metric queries are not supported.
+//	NOTE: only streams of the X-Scope-OrgID tenant are queried, if the header is set
+//
+func (rcv *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
+	var (
+		params    = r.URL.Query()
+		start     = int64(0)
+		end       = time.Now().UnixNano()
+		limit     = defaultQueryLimit
+		direction = params.Get("direction")
+		tenantID  = r.Header.Get(tenantHeader)
+		err       error
+	)
+
+	selector, err := ParseSelector(params.Get("query"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if raw := params.Get("start"); raw != "" {
+		if start, err = strconv.ParseInt(raw, 10, 64); err != nil {
+			http.Error(w, "invalid start: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if raw := params.Get("end"); raw != "" {
+		if end, err = strconv.ParseInt(raw, 10, 64); err != nil {
+			http.Error(w, "invalid end: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if raw := params.Get("limit"); raw != "" {
+		if limit, err = strconv.Atoi(raw); err != nil {
+			http.Error(w, "invalid limit: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	type selectedEntry struct {
+		labels map[string]string
+		entry  Entry
+	}
+
+	var selected []selectedEntry
+
+	rcv.mu.Lock()
+	for _, stream := range rcv.streams {
+		if !selector.MatchLabels(stream.Labels) || (tenantID != "" && stream.TenantID != tenantID) {
+			continue
+		}
+		for _, entry := range stream.Entries {
+			ts := entry.Timestamp.UnixNano()
+			if start <= ts && ts < end && selector.MatchLine(entry.Line) {
+				selected = append(selected, selectedEntry{labels: stream.Labels, entry: entry})
+			}
+		}
+	}
+	rcv.mu.Unlock()
+
+	sort.SliceStable(selected, func(i, j int) bool {
+		if direction == "forward" {
+			return selected[i].entry.Timestamp.Before(selected[j].entry.Timestamp)
+		}
+		return selected[i].entry.Timestamp.After(selected[j].entry.Timestamp)
+	})
+
+	if limit > 0 && len(selected) > limit {
+		selected = selected[:limit]
+	}
+
+	response := &queryResponseDTO{Status: "success"}
+	response.Data.ResultType = "streams"
+	response.Data.Result = []*queryStreamDTO{}
+
+	streams := make(map[string]*queryStreamDTO)
+	for i := range selected {
+		key := logproto.FormatLabels(selected[i].labels)
+
+		stream, ok := streams[key]
+		if !ok {
+			stream = &queryStreamDTO{Stream: selected[i].labels}
+			streams[key] = stream
+			response.Data.Result = append(response.Data.Result, stream)
+		}
+
+		value := []interface{}{
+			strconv.FormatInt(selected[i].entry.Timestamp.UnixNano(), 10),
+			selected[i].entry.Line,
+		}
+		if len(selected[i].entry.Metadata) > 0 {
+			value = append(value, selected[i].entry.Metadata)
+		}
+		stream.Values = append(stream.Values, value)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(response)
+}
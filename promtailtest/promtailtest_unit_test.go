@@ -0,0 +1,211 @@
+// +build unit
+
+package promtailtest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ic2hrmk/promtail"
+)
+
+type recordingT struct {
+	failures []string
+}
+
+func (rcv *recordingT) Helper() {}
+
+func (rcv *recordingT) Fatalf(format string, args ...interface{}) {
+	rcv.failures = append(rcv.failures, fmt.Sprintf(format, args...))
+}
+
+func TestServer_Push(t *testing.T) {
+	tests := []struct {
+		name      string
+		newClient func(address string, labels map[string]string) (promtail.Client, error)
+	}{
+		{name: "JSON", newClient: func(address string, labels map[string]string) (promtail.Client, error) {
+			return promtail.NewJSONv1Client(address, labels)
+		}},
+		{name: "Protobuf", newClient: func(address string, labels map[string]string) (promtail.Client, error) {
+			return promtail.NewProtoV1Client(address, labels)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := NewServer()
+			defer server.Close()
+
+			client, err := tt.newClient(server.URL, map[string]string{"app": "api"})
+			if err != nil {
+				t.Fatalf("failed to create client: %s", err)
+			}
+			defer client.Close()
+
+			client.Infof("user %s logged in", "alice")
+			client.LogKV(promtail.Error, "payment failed", "order", 42)
+			client.Flush()
+
+			entry := server.RequireEntry(t, `{app="api", logLevel="INFO"}`, "alice")
+			if entry.Line != "INFO: user alice logged in" {
+				t.Errorf("unexpected line: %s", entry.Line)
+			}
+
+			entry = server.RequireEntry(t, `{app=~"a.i"} |= "payment"`, "failed")
+			if !reflect.DeepEqual(entry.Metadata, map[string]string{"order": "42"}) {
+				t.Errorf("unexpected metadata: %v", entry.Metadata)
+			}
+
+			if pong, err := client.Ping(); err != nil || !pong.IsReady {
+				t.Errorf("Ping() = %v, %v, want ready", pong, err)
+			}
+		})
+	}
+}
+
+func TestServer_InjectFailures(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+
+	server.InjectFailures(EndpointPush,
+		Failure{StatusCode: http.StatusServiceUnavailable},
+		Failure{DropConnection: true},
+		Failure{Latency: 50 * time.Millisecond},
+	)
+
+	client, err := promtail.NewJSONv1Client(server.URL, map[string]string{"app": "api"},
+		promtail.WithSendBatchSize(1),
+		promtail.WithRetryPolicy(time.Millisecond, 10*time.Millisecond, 5),
+	)
+	if err != nil {
+		t.Fatalf("failed to create client: %s", err)
+	}
+	defer client.Close()
+
+	client.Warnf("disk is almost full")
+
+	server.RequireEntry(t, `{app="api"}`, "disk")
+
+	if requests := server.Requests(EndpointPush); requests != 3 {
+		t.Errorf("push requests = %d, want 3", requests)
+	}
+}
+
+// Mirrors the "How to test" example of README, keep them in sync
+func TestServer_RetriedPush(t *testing.T) {
+	loki := NewServer()
+	defer loki.Close()
+
+	// Next push fails, so the client has to retry it
+	loki.InjectFailures(EndpointPush, Failure{StatusCode: 503})
+
+	promtailClient, err := promtail.NewJSONv1Client(loki.URL, map[string]string{"app": "shop"},
+		promtail.WithRetryPolicy(10*time.Millisecond, 100*time.Millisecond, 3),
+	)
+	if err != nil {
+		t.Fatalf("failed to create client: %s", err)
+	}
+	defer promtailClient.Close()
+
+	promtailClient.Errorf("payment %d failed", 42)
+	promtailClient.Flush() // Pushes the batch right away, without waiting for the batch timeout
+
+	loki.RequireEntry(t, `{app="shop", logLevel="ERROR"}`, "payment 42")
+
+	if requests := loki.Requests(EndpointPush); requests != 2 {
+		t.Errorf("push requests = %d, want 2", requests)
+	}
+}
+
+func TestServer_RequireEntryFailure(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+
+	server.SetWaitTimeout(50 * time.Millisecond)
+
+	recorder := &recordingT{}
+	server.RequireEntry(recorder, `{app="api"}`, "missing")
+
+	if len(recorder.failures) != 1 {
+		t.Errorf("RequireEntry() failures = %v, want exactly one", recorder.failures)
+	}
+}
+
+func TestServer_Query(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+
+	client, err := promtail.NewJSONv1Client(server.URL, map[string]string{"app": "api"})
+	if err != nil {
+		t.Fatalf("failed to create client: %s", err)
+	}
+
+	client.Infof("first")
+	client.Errorf("second")
+	client.Infof("third")
+	client.Close()
+
+	server.RequireEntry(t, `{app="api"}`, "third")
+
+	result, err := promtail.NewQueryClient(server.URL).QueryRange(context.Background(),
+		`{app="api"} != "second"`, time.Now().Add(-time.Hour), time.Now().Add(time.Hour), 10, promtail.Forward)
+	if err != nil {
+		t.Fatalf("QueryRange() error = %s", err)
+	}
+
+	var lines []string
+	for _, stream := range result.Streams {
+		for _, entry := range stream.Entries {
+			lines = append(lines, entry.Line)
+		}
+	}
+
+	if expected := []string{"INFO: first", "INFO: third"}; !reflect.DeepEqual(lines, expected) {
+		t.Errorf("queried lines = %v, want %v", lines, expected)
+	}
+}
+
+func TestParseSelector(t *testing.T) {
+	labels := map[string]string{"app": "api", "level": "ERROR"}
+
+	tests := []struct {
+		name        string
+		selector    string
+		line        string
+		wantErr     bool
+		wantMatched bool
+	}{
+		{name: "Equal", selector: `{app="api"}`, line: "x", wantMatched: true},
+		{name: "Not equal", selector: `{app!="api"}`, line: "x", wantMatched: false},
+		{name: "Regexp is anchored", selector: `{app=~"ap"}`, line: "x", wantMatched: false},
+		{name: "Not regexp", selector: `{level!~"WARN|INFO"}`, line: "x", wantMatched: true},
+		{name: "Absent label", selector: `{host=""}`, line: "x", wantMatched: true},
+		{name: "Line filters", selector: "{app=\"api\"} |= `time` !~ \"retry\\\\d\"", line: "timeout, retry1", wantMatched: false},
+		{name: "Escaped quotes", selector: `{app="api"} |= "\"id\""`, line: `{"id":1}`, wantMatched: true},
+		{name: "Missing braces", selector: `app="api"`, wantErr: true},
+		{name: "Unclosed selector", selector: `{app="api"`, wantErr: true},
+		{name: "Invalid operator", selector: `{app>"api"}`, wantErr: true},
+		{name: "Unsupported stage", selector: `{app="api"} | json`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selector, err := ParseSelector(tt.selector)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSelector() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if matched := selector.MatchLabels(labels) && selector.MatchLine(tt.line); matched != tt.wantMatched {
+				t.Errorf("matched = %v, want %v", matched, tt.wantMatched)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package promtailtest
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//
+// Selector is a minimal LogQL subset: a stream selector with =, !=, =~, !~ matchers,
+// optionally followed by |=, !=, |~, !~ line filters
+//	Example: {app="api", level=~"ERROR|WARN"} |= "timeout" != "retry"
+//
+type Selector struct {
+	matchers []labelMatcher
+	filters  []lineFilter
+}
+
+type labelMatcher struct {
+	name   string
+	op     string
+	value  string
+	regexp *regexp.Regexp
+}
+
+type lineFilter struct {
+	op     string
+	value  string
+	regexp *regexp.Regexp
+}
+
+func ParseSelector(s string) (*Selector, error) {
+	rest := strings.TrimSpace(s)
+	if !strings.HasPrefix(rest, "{") {
+		return nil, fmt.Errorf("selector must start with a stream selector: %s", s)
+	}
+	rest = strings.TrimSpace(rest[1:])
+
+	selector := &Selector{}
+
+	for !strings.HasPrefix(rest, "}") {
+		i := strings.IndexAny(rest, "=!")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid label matcher in selector: %s", s)
+		}
+		name := strings.TrimSpace(rest[:i])
+		rest = rest[i:]
+
+		op := takeOperator(rest, "=~", "!~", "!=", "=")
+		if op == "" {
+			return nil, fmt.Errorf("invalid operator of label [%s] in selector: %s", name, s)
+		}
+		rest = strings.TrimSpace(rest[len(op):])
+
+		value, tail, err := takeQuoted(rest)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of label [%s] in selector: %s", name, err)
+		}
+		rest = strings.TrimSpace(tail)
+
+		matcher := labelMatcher{name: name, op: op, value: value}
+		if op == "=~" || op == "!~" {
+			// Label regular expressions are fully anchored in LogQL
+			if matcher.regexp, err = regexp.Compile("^(?:" + value + ")$"); err != nil {
+				return nil, fmt.Errorf("invalid regexp of label [%s]: %s", name, err)
+			}
+		}
+		selector.matchers = append(selector.matchers, matcher)
+
+		rest = strings.TrimSpace(strings.TrimPrefix(rest, ","))
+		if rest == "" {
+			return nil, fmt.Errorf("unclosed stream selector: %s", s)
+		}
+	}
+	rest = strings.TrimSpace(rest[1:])
+
+	for rest != "" {
+		op := takeOperator(rest, "|=", "!=", "|~", "!~")
+		if op == "" {
+			return nil, fmt.Errorf("unsupported pipeline stage in selector: %s", rest)
+		}
+		rest = strings.TrimSpace(rest[len(op):])
+
+		value, tail, err := takeQuoted(rest)
+		if err != nil {
+			return nil, fmt.Errorf("invalid line filter in selector: %s", err)
+		}
+		rest = strings.TrimSpace(tail)
+
+		filter := lineFilter{op: op, value: value}
+		if op == "|~" || op == "!~" {
+			if filter.regexp, err = regexp.Compile(value); err != nil {
+				return nil, fmt.Errorf("invalid line filter regexp: %s", err)
+			}
+		}
+		selector.filters = append(selector.filters, filter)
+	}
+
+	return selector, nil
+}
+
+// Checks whether the labels match the stream selector, absent labels are treated as empty ones
+func (rcv *Selector) MatchLabels(labels map[string]string) bool {
+	for _, matcher := range rcv.matchers {
+		value := labels[matcher.name]
+
+		var ok bool
+		switch matcher.op {
+		case "=":
+			ok = value == matcher.value
+		case "!=":
+			ok = value != matcher.value
+		case "=~":
+			ok = matcher.regexp.MatchString(value)
+		case "!~":
+			ok = !matcher.regexp.MatchString(value)
+		}
+
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (rcv *Selector) MatchLine(line string) bool {
+	for _, filter := range rcv.filters {
+		var ok bool
+		switch filter.op {
+		case "|=":
+			ok = strings.Contains(line, filter.value)
+		case "!=":
+			ok = !strings.Contains(line, filter.value)
+		case "|~":
+			ok = filter.regexp.MatchString(line)
+		case "!~":
+			ok = !filter.regexp.MatchString(line)
+		}
+
+		if !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+func takeOperator(s string, operators ...string) string {
+	for _, op := range operators {
+		if strings.HasPrefix(s, op) {
+			return op
+		}
+	}
+	return ""
+}
+
+// Splits a double-quoted or backticked string at the beginning of s from the rest of it
+func takeQuoted(s string) (value string, rest string, err error) {
+	if strings.HasPrefix(s, "`") {
+		end := strings.IndexByte(s[1:], '`')
+		if end < 0 {
+			return "", "", fmt.Errorf("unclosed raw string: %s", s)
+		}
+		return s[1 : end+1], s[end+2:], nil
+	}
+
+	if !strings.HasPrefix(s, `"`) {
+		return "", "", fmt.Errorf("quoted string is expected: %s", s)
+	}
+
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			value, err = strconv.Unquote(s[:i+1])
+			return value, s[i+1:], err
+		}
+	}
+
+	return "", "", fmt.Errorf("unclosed string: %s", s)
+}
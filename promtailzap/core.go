@@ -0,0 +1,186 @@
+//
+// Package promtailzap provides a zapcore.Core which ships zap entries to Loki
+// through the batching promtail client
+//
+package promtailzap
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ic2hrmk/promtail"
+	"github.com/ic2hrmk/promtail/logfmt"
+	"go.uber.org/zap/zapcore"
+)
+
+//
+// Core renders an entry's message and fields as a logfmt line and passes it
+// to promtail.Client, fields selected with WithLabelKeys() become stream labels
+//
+type Core struct {
+	zapcore.LevelEnabler
+
+	client    promtail.Client
+	labelKeys map[string]struct{}
+	fields    []zapcore.Field
+}
+
+type coreOption func(c *Core)
+
+//
+// Creates a core, which forwards entries of enabled levels into the client's queue
+//	NOTE: options are applied in the order they are passed
+//
+func NewCore(client promtail.Client, enabler zapcore.LevelEnabler, options ...coreOption) *Core {
+	c := &Core{
+		LevelEnabler: enabler,
+		client:       client,
+		labelKeys:    make(map[string]struct{}),
+	}
+
+	for i := range options {
+		options[i](c)
+	}
+
+	return c
+}
+
+//
+// Fields with given keys become stream labels instead of being rendered into the line.
+// Fields inside namespaces are addressed with dot-separated keys, e.g. "request.method"
+//
+func WithLabelKeys(keys ...string) coreOption {
+	return func(c *Core) {
+		for i := range keys {
+			c.labelKeys[keys[i]] = struct{}{}
+		}
+	}
+}
+
+func (c *Core) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.fields = make([]zapcore.Field, 0, len(c.fields)+len(fields))
+	clone.fields = append(clone.fields, c.fields...)
+	clone.fields = append(clone.fields, fields...)
+	return &clone
+}
+
+func (c *Core) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c *Core) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	encoder := zapcore.NewMapObjectEncoder()
+
+	for i := range c.fields {
+		c.fields[i].AddTo(encoder)
+	}
+	for i := range fields {
+		fields[i].AddTo(encoder)
+	}
+
+	var (
+		flatFields = make(map[string]string, len(encoder.Fields))
+		labels     map[string]string
+	)
+
+	flattenFields(flatFields, "", encoder.Fields)
+
+	keys := make([]string, 0, len(flatFields))
+	for key := range flatFields {
+		if _, isLabel := c.labelKeys[key]; isLabel {
+			if labels == nil {
+				labels = make(map[string]string)
+			}
+			labels[labelName(key)] = flatFields[key]
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	line := make([]byte, 0, len(entry.Message)+64)
+	line = append(line, entry.Message...)
+
+	if entry.LoggerName != "" {
+		line = logfmt.AppendPair(line, "logger", entry.LoggerName)
+	}
+	if entry.Caller.Defined {
+		line = logfmt.AppendPair(line, "caller", entry.Caller.TrimmedPath())
+	}
+	for i := range keys {
+		line = logfmt.AppendPair(line, keys[i], flatFields[keys[i]])
+	}
+	if entry.Stack != "" {
+		line = append(line, '\n')
+		line = append(line, entry.Stack...)
+	}
+
+	logLine(c.client, mapLevel(entry.Level), labels, entry.Message, string(line))
+
+	// The process is likely to exit right after, so don't keep the entry in a batch
+	if entry.Level > zapcore.ErrorLevel {
+		return c.Sync()
+	}
+
+	return nil
+}
+
+// Pushes the client's current batch
+func (c *Core) Sync() error {
+	c.client.Flush()
+	return nil
+}
+
+// Namespaces and nested objects are flattened into dot-separated keys
+func flattenFields(dst map[string]string, prefix string, fields map[string]interface{}) {
+	for key, value := range fields {
+		switch typed := value.(type) {
+		case map[string]interface{}:
+			flattenFields(dst, prefix+key+".", typed)
+		case time.Time:
+			dst[prefix+key] = typed.Format(time.RFC3339Nano)
+		default:
+			dst[prefix+key] = fmt.Sprint(value)
+		}
+	}
+}
+
+// Loki doesn't accept dots in label names
+func labelName(key string) string {
+	return strings.ReplaceAll(key, ".", "_")
+}
+
+func mapLevel(level zapcore.Level) promtail.Level {
+	switch {
+	case level <= zapcore.DebugLevel:
+		return promtail.Debug
+	case level == zapcore.InfoLevel:
+		return promtail.Info
+	case level == zapcore.WarnLevel:
+		return promtail.Warn
+	case level == zapcore.ErrorLevel:
+		return promtail.Error
+	case level >= zapcore.FatalLevel:
+		return promtail.Fatal
+	default: // DPanic and Panic
+		return promtail.Panic
+	}
+}
+
+//
+// Logs the line, sampled by the message without fields, if the client supports it
+//
+func logLine(client promtail.Client, level promtail.Level, labels map[string]string, message, line string) {
+	if templateLogger, ok := client.(promtail.TemplateLogger); ok {
+		templateLogger.LogWithTemplate(level, labels, message, line)
+		return
+	}
+
+	client.LogfWithLabels(level, labels, "%s", line)
+}
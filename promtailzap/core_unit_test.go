@@ -0,0 +1,142 @@
+// +build unit
+
+package promtailzap
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ic2hrmk/promtail"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type loggedEntry struct {
+	level  promtail.Level
+	labels map[string]string
+	line   string
+}
+
+// Client which records log calls instead of sending them
+type fakeClient struct {
+	promtail.Client
+	entries []loggedEntry
+	flushes int
+}
+
+func (rcv *fakeClient) LogfWithLabels(level promtail.Level, labels map[string]string, format string, args ...interface{}) {
+	rcv.entries = append(rcv.entries, loggedEntry{
+		level:  level,
+		labels: labels,
+		line:   args[0].(string),
+	})
+}
+
+func (rcv *fakeClient) Flush() {
+	rcv.flushes++
+}
+
+func TestCore(t *testing.T) {
+	var (
+		client = &fakeClient{}
+		logger = zap.New(NewCore(client, zapcore.InfoLevel,
+			WithLabelKeys("service", "request.method"),
+		))
+	)
+
+	logger.
+		Named("payments").
+		With(zap.String("service", "billing"), zap.String("version", "1.2.3")).
+		Warn("payment declined",
+			zap.Int("amount", 42),
+			zap.Namespace("request"),
+			zap.String("method", "POST"),
+			zap.String("path", "/pay now"),
+		)
+
+	logger.Debug("disabled level")
+	logger.DPanic("should not happen")
+
+	want := []loggedEntry{
+		{
+			level: promtail.Warn,
+			labels: map[string]string{
+				"service":        "billing",
+				"request_method": "POST",
+			},
+			line: `payment declined logger=payments amount=42 request.path="/pay now" version=1.2.3`,
+		},
+		{
+			level: promtail.Panic,
+			line:  "should not happen",
+		},
+	}
+
+	if !reflect.DeepEqual(client.entries, want) {
+		t.Errorf("unexpected entries:\n got  = %+v\n want = %+v", client.entries, want)
+	}
+
+	// Entries above error level are flushed immediately
+	if client.flushes != 1 {
+		t.Errorf("unexpected number of flushes, got: %d, want: %d", client.flushes, 1)
+	}
+
+	_ = logger.Sync()
+
+	if client.flushes != 2 {
+		t.Errorf("sync must flush the client, flushes: %d", client.flushes)
+	}
+}
+
+func TestMapLevel(t *testing.T) {
+	tests := []struct {
+		level zapcore.Level
+		want  promtail.Level
+	}{
+		{level: zapcore.DebugLevel, want: promtail.Debug},
+		{level: zapcore.InfoLevel, want: promtail.Info},
+		{level: zapcore.WarnLevel, want: promtail.Warn},
+		{level: zapcore.ErrorLevel, want: promtail.Error},
+		{level: zapcore.DPanicLevel, want: promtail.Panic},
+		{level: zapcore.PanicLevel, want: promtail.Panic},
+		{level: zapcore.FatalLevel, want: promtail.Fatal},
+	}
+
+	for _, tt := range tests {
+		if got := mapLevel(tt.level); got != tt.want {
+			t.Errorf("unexpected mapping of %s, got: %s, want: %s", tt.level, got, tt.want)
+		}
+	}
+}
+
+// Client which records templates of prepared lines as well
+type fakeTemplateClient struct {
+	fakeClient
+	templates []string
+}
+
+func (rcv *fakeTemplateClient) LogWithTemplate(level promtail.Level, labels map[string]string, template, line string) {
+	rcv.templates = append(rcv.templates, template)
+	rcv.LogfWithLabels(level, labels, "%s", line)
+}
+
+func TestCore_Template(t *testing.T) {
+	client := &fakeTemplateClient{}
+	logger := zap.New(NewCore(client, zapcore.InfoLevel))
+
+	logger.Info("payment declined", zap.Int("amount", 42))
+	logger.Info("payment declined", zap.Int("amount", 43))
+
+	wantLines := []string{"payment declined amount=42", "payment declined amount=43"}
+	for i := range client.entries {
+		if i >= len(wantLines) || client.entries[i].line != wantLines[i] {
+			t.Errorf("unexpected lines: %+v, want: %q", client.entries, wantLines)
+			break
+		}
+	}
+
+	// Lines with different fields are sampled as one message
+	if want := []string{"payment declined", "payment declined"}; !reflect.DeepEqual(client.templates, want) {
+		t.Errorf("unexpected templates: %q, want: %q", client.templates, want)
+	}
+}
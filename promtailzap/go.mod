@@ -0,0 +1,13 @@
+module github.com/ic2hrmk/promtail/promtailzap
+
+go 1.21
+
+require (
+	github.com/ic2hrmk/promtail v0.0.0
+	go.uber.org/zap v1.27.0
+)
+
+require go.uber.org/multierr v1.10.0 // indirect
+
+// Until the client is tagged, it is taken from the parent directory
+replace github.com/ic2hrmk/promtail => ../
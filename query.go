@@ -0,0 +1,457 @@
+package promtail
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ic2hrmk/promtail/internal/logproto"
+)
+
+type Direction string
+
+const (
+	Forward  Direction = "forward"
+	Backward Direction = "backward"
+)
+
+const (
+	ResultTypeStreams = "streams"
+	ResultTypeMatrix  = "matrix"
+	ResultTypeVector  = "vector"
+
+	// Loki's default `max_entries_limit_per_query`
+	defaultQueryPageSize = 5000
+)
+
+type QueryResult struct {
+	ResultType string
+
+	// Set for log queries
+	Streams []*QueryStream
+	// Set for metric queries over a range
+	Matrix []*QuerySeries
+	// Set for instant metric queries
+	Vector []*QuerySample
+}
+
+type QueryStream struct {
+	Labels  map[string]string
+	Entries []*QueryEntry
+}
+
+type QueryEntry struct {
+	Timestamp time.Time
+	Line      string
+	Metadata  map[string]string
+}
+
+type QuerySeries struct {
+	Metric map[string]string
+	Values []QueryPoint
+}
+
+type QuerySample struct {
+	Metric map[string]string
+	QueryPoint
+}
+
+type QueryPoint struct {
+	Timestamp time.Time
+	Value     float64
+}
+
+//
+// Creates a client capable to read logs back via Loki v1 query API
+//	Read more at: https://github.com/grafana/loki/blob/master/docs/api.md#get-lokiapiv1query_range
+//
+func NewQueryClient(lokiAddress string) *QueryClient {
+	return &QueryClient{
+		lokiRESTClient: newLokiRESTClient(normalizeLokiAddress(lokiAddress)),
+		pageSize:       defaultQueryPageSize,
+	}
+}
+
+type QueryClient struct {
+	lokiRESTClient
+	pageSize int
+}
+
+//
+//	Data transfer objects are restored from `query API` description:
+//		https://github.com/grafana/loki/blob/master/docs/api.md#get-lokiapiv1query
+//	{
+//		"status": "success",
+//		"data": {
+//			"resultType": "streams" | "matrix" | "vector",
+//			"result": [
+//				{ "stream": {"label": "value"}, "values": [ [ "<unix epoch in nanoseconds>", "<log line>" ] ] },
+//				{ "metric": {"label": "value"}, "values": [ [ <unix epoch in seconds>, "<value>" ] ] },
+//				{ "metric": {"label": "value"}, "value": [ <unix epoch in seconds>, "<value>" ] }
+//			]
+//		}
+//	}
+//
+type (
+	lokiDTOJsonV1QueryResponse struct {
+		Status string `json:"status"`
+		Data   struct {
+			ResultType string          `json:"resultType"`
+			Result     json.RawMessage `json:"result"`
+		} `json:"data"`
+	}
+
+	lokiDTOJsonV1Series struct {
+		Metric map[string]string    `json:"metric"`
+		Values []lokiDTOJsonV1Point `json:"values"`
+	}
+
+	lokiDTOJsonV1Sample struct {
+		Metric map[string]string  `json:"metric"`
+		Value  lokiDTOJsonV1Point `json:"value"`
+	}
+
+	lokiDTOJsonV1Point [2]interface{}
+)
+
+//
+// Sets the maximum number of entries requested at once, bigger limits of QueryRange()
+// are fetched page by page (Loki's `max_entries_limit_per_query` is 5000 by default)
+//
+func (rcv *QueryClient) SetPageSize(pageSize int) {
+	if pageSize > 0 {
+		rcv.pageSize = pageSize
+	}
+}
+
+// Performs an instant query at a given time (zero time stands for now)
+func (rcv *QueryClient) Query(ctx context.Context, logql string, at time.Time) (*QueryResult, error) {
+	params := url.Values{"query": {logql}}
+	if !at.IsZero() {
+		params.Set("time", strconv.FormatInt(at.UnixNano(), 10))
+	}
+
+	return rcv.query(ctx, "/loki/api/v1/query", params)
+}
+
+//
+// Performs a query over [start, end) range, log queries return up to limit entries
+// fetched page by page in the given direction
+//
+func (rcv *QueryClient) QueryRange(
+	ctx context.Context, logql string, start, end time.Time, limit int, direction Direction,
+) (*QueryResult, error) {
+	if direction == "" {
+		direction = Backward
+	}
+
+	var (
+		result   *QueryResult
+		streams  = newQueryStreamsCollector()
+		boundary = queryPageBoundary{}
+	)
+
+	for fetched := 0; limit <= 0 || fetched < limit; {
+		pageLimit := rcv.pageSize
+		if limit > 0 && limit-fetched < pageLimit {
+			pageLimit = limit - fetched
+		}
+
+		// Already seen entries of the boundary timestamp come again, so they're requested on top
+		pageLimit += len(boundary.entries)
+
+		page, err := rcv.query(ctx, "/loki/api/v1/query_range", url.Values{
+			"query":     {logql},
+			"start":     {strconv.FormatInt(start.UnixNano(), 10)},
+			"end":       {strconv.FormatInt(end.UnixNano(), 10)},
+			"limit":     {strconv.Itoa(pageLimit)},
+			"direction": {string(direction)},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// Only log queries are limited by entries
+		if page.ResultType != ResultTypeStreams {
+			return page, nil
+		}
+		result = page
+
+		var (
+			pageEntries  = 0
+			newEntries   = 0
+			nextBoundary = queryPageBoundary{}
+		)
+
+		for _, stream := range page.Streams {
+			for _, entry := range stream.Entries {
+				pageEntries++
+				nextBoundary.track(stream.Labels, entry, direction)
+
+				// Entries at the boundary timestamp were probably seen on the previous page
+				if boundary.contains(stream.Labels, entry) {
+					continue
+				}
+
+				if limit <= 0 || fetched < limit {
+					streams.add(stream.Labels, entry)
+					fetched++
+					newEntries++
+				}
+			}
+		}
+
+		// Either everything is fetched, or the page is stuck on entries sharing one timestamp
+		if pageEntries < pageLimit || newEntries == 0 {
+			break
+		}
+
+		// The boundary timestamp is requested again as several entries may share it
+		if direction == Forward {
+			start = nextBoundary.timestamp
+		} else {
+			end = nextBoundary.timestamp.Add(time.Nanosecond)
+		}
+		boundary = nextBoundary
+	}
+
+	if result == nil {
+		result = &QueryResult{ResultType: ResultTypeStreams}
+	}
+	result.Streams = streams.streams(direction)
+
+	return result, nil
+}
+
+func (rcv *QueryClient) query(ctx context.Context, path string, params url.Values) (*QueryResult, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rcv.lokiAddress+path+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %s", err)
+	}
+
+	if rcv.tenantID != "" {
+		req.Header.Set(tenantHeader, rcv.tenantID)
+	}
+
+	if err = rcv.authenticate(req); err != nil {
+		return nil, err
+	}
+
+	resp, err := rcv.restClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send query: %s", err)
+	}
+
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read query response: %s", err)
+	}
+
+	if !rcv.isSuccessHTTPCode(resp.StatusCode) {
+		return nil, &UnexpectedResponseError{
+			StatusCode: resp.StatusCode,
+			Message:    string(body),
+		}
+	}
+
+	queryResponse := &lokiDTOJsonV1QueryResponse{}
+	if err = json.Unmarshal(body, queryResponse); err != nil {
+		return nil, fmt.Errorf("failed to decode query response: %s", err)
+	}
+
+	return rcv.transformDTOToQueryResult(queryResponse)
+}
+
+func (rcv *QueryClient) transformDTOToQueryResult(queryResponse *lokiDTOJsonV1QueryResponse) (*QueryResult, error) {
+	result := &QueryResult{ResultType: queryResponse.Data.ResultType}
+
+	switch queryResponse.Data.ResultType {
+	case ResultTypeStreams:
+		var lokiStreams []*lokiDTOJsonV1Stream
+		if err := json.Unmarshal(queryResponse.Data.Result, &lokiStreams); err != nil {
+			return nil, fmt.Errorf("failed to decode streams: %s", err)
+		}
+
+		for i := range lokiStreams {
+			stream := &QueryStream{
+				Labels:  lokiStreams[i].Stream,
+				Entries: make([]*QueryEntry, 0, len(lokiStreams[i].Values)),
+			}
+
+			for j := range lokiStreams[i].Values {
+				timestamp, err := strconv.ParseInt(lokiStreams[i].Values[j].Timestamp, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid entry timestamp: %s", err)
+				}
+
+				stream.Entries = append(stream.Entries, &QueryEntry{
+					Timestamp: time.Unix(0, timestamp),
+					Line:      lokiStreams[i].Values[j].Line,
+					Metadata:  lokiStreams[i].Values[j].Metadata,
+				})
+			}
+
+			result.Streams = append(result.Streams, stream)
+		}
+
+	case ResultTypeMatrix:
+		var lokiSeries []*lokiDTOJsonV1Series
+		if err := json.Unmarshal(queryResponse.Data.Result, &lokiSeries); err != nil {
+			return nil, fmt.Errorf("failed to decode matrix: %s", err)
+		}
+
+		for i := range lokiSeries {
+			series := &QuerySeries{
+				Metric: lokiSeries[i].Metric,
+				Values: make([]QueryPoint, 0, len(lokiSeries[i].Values)),
+			}
+
+			for j := range lokiSeries[i].Values {
+				point, err := lokiSeries[i].Values[j].toQueryPoint()
+				if err != nil {
+					return nil, err
+				}
+				series.Values = append(series.Values, point)
+			}
+
+			result.Matrix = append(result.Matrix, series)
+		}
+
+	case ResultTypeVector:
+		var lokiSamples []*lokiDTOJsonV1Sample
+		if err := json.Unmarshal(queryResponse.Data.Result, &lokiSamples); err != nil {
+			return nil, fmt.Errorf("failed to decode vector: %s", err)
+		}
+
+		for i := range lokiSamples {
+			point, err := lokiSamples[i].Value.toQueryPoint()
+			if err != nil {
+				return nil, err
+			}
+
+			result.Vector = append(result.Vector, &QuerySample{
+				Metric:     lokiSamples[i].Metric,
+				QueryPoint: point,
+			})
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported result type: %s", queryResponse.Data.ResultType)
+	}
+
+	return result, nil
+}
+
+func (rcv lokiDTOJsonV1Point) toQueryPoint() (QueryPoint, error) {
+	seconds, ok := rcv[0].(float64)
+	if !ok {
+		return QueryPoint{}, fmt.Errorf("invalid sample timestamp: %v", rcv[0])
+	}
+
+	rawValue, ok := rcv[1].(string)
+	if !ok {
+		return QueryPoint{}, fmt.Errorf("invalid sample value: %v", rcv[1])
+	}
+
+	value, err := strconv.ParseFloat(rawValue, 64)
+	if err != nil {
+		return QueryPoint{}, fmt.Errorf("invalid sample value: %s", err)
+	}
+
+	whole, fraction := math.Modf(seconds)
+
+	return QueryPoint{
+		Timestamp: time.Unix(int64(whole), int64(math.Round(fraction*1e3))*int64(time.Millisecond)),
+		Value:     value,
+	}, nil
+}
+
+//
+// Remembers entries sharing the outermost timestamp of a page, as the next page
+// starts from the same timestamp and would return them again
+//
+type queryPageBoundary struct {
+	timestamp time.Time
+	entries   map[string]struct{}
+}
+
+func (rcv *queryPageBoundary) track(labels map[string]string, entry *QueryEntry, direction Direction) {
+	isOuter := rcv.timestamp.IsZero() ||
+		(direction == Forward && entry.Timestamp.After(rcv.timestamp)) ||
+		(direction == Backward && entry.Timestamp.Before(rcv.timestamp))
+
+	if isOuter {
+		rcv.timestamp = entry.Timestamp
+		rcv.entries = make(map[string]struct{})
+	}
+
+	if entry.Timestamp.Equal(rcv.timestamp) {
+		rcv.entries[rcv.key(labels, entry)] = struct{}{}
+	}
+}
+
+func (rcv *queryPageBoundary) contains(labels map[string]string, entry *QueryEntry) bool {
+	if rcv.timestamp.IsZero() || !entry.Timestamp.Equal(rcv.timestamp) {
+		return false
+	}
+
+	_, ok := rcv.entries[rcv.key(labels, entry)]
+	return ok
+}
+
+func (rcv *queryPageBoundary) key(labels map[string]string, entry *QueryEntry) string {
+	return logproto.FormatLabels(labels) + "\x00" + entry.Line
+}
+
+//
+// Merges entries of the same streams from several pages
+//
+type queryStreamsCollector struct {
+	order []string
+	index map[string]*QueryStream
+}
+
+func newQueryStreamsCollector() *queryStreamsCollector {
+	return &queryStreamsCollector{index: make(map[string]*QueryStream)}
+}
+
+func (rcv *queryStreamsCollector) add(labels map[string]string, entry *QueryEntry) {
+	key := logproto.FormatLabels(labels)
+
+	stream, ok := rcv.index[key]
+	if !ok {
+		stream = &QueryStream{Labels: labels}
+		rcv.index[key] = stream
+		rcv.order = append(rcv.order, key)
+	}
+
+	stream.Entries = append(stream.Entries, entry)
+}
+
+func (rcv *queryStreamsCollector) streams(direction Direction) []*QueryStream {
+	streams := make([]*QueryStream, 0, len(rcv.order))
+
+	for i := range rcv.order {
+		stream := rcv.index[rcv.order[i]]
+
+		sort.SliceStable(stream.Entries, func(i, j int) bool {
+			if direction == Forward {
+				return stream.Entries[i].Timestamp.Before(stream.Entries[j].Timestamp)
+			}
+			return stream.Entries[i].Timestamp.After(stream.Entries[j].Timestamp)
+		})
+
+		streams = append(streams, stream)
+	}
+
+	return streams
+}
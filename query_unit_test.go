@@ -0,0 +1,244 @@
+// +build unit
+
+package promtail
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeLokiEntry struct {
+	labels    map[string]string
+	timestamp int64
+	line      string
+}
+
+//
+// Serves query_range over a fixed set of entries the way Loki does: entries from
+// [start, end) are sorted in the direction and cut by limit
+//
+func newFakeQueryServer(t *testing.T, entries []fakeLokiEntry, requests *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+
+		var (
+			query     = r.URL.Query()
+			start, _  = strconv.ParseInt(query.Get("start"), 10, 64)
+			end, _    = strconv.ParseInt(query.Get("end"), 10, 64)
+			limit, _  = strconv.Atoi(query.Get("limit"))
+			direction = query.Get("direction")
+		)
+
+		if strings.HasPrefix(query.Get("query"), "rate(") {
+			_, _ = fmt.Fprint(w, `{"status":"success","data":{"resultType":"matrix","result":[`+
+				`{"metric":{"app":"a"},"values":[[1588889221,"1.5"],[1588889231.5,"2"]]}]}}`)
+			return
+		}
+
+		var selected []fakeLokiEntry
+		for i := range entries {
+			if start <= entries[i].timestamp && entries[i].timestamp < end {
+				selected = append(selected, entries[i])
+			}
+		}
+
+		sort.SliceStable(selected, func(i, j int) bool {
+			if direction == "forward" {
+				return selected[i].timestamp < selected[j].timestamp
+			}
+			return selected[i].timestamp > selected[j].timestamp
+		})
+
+		if len(selected) > limit {
+			selected = selected[:limit]
+		}
+
+		var result []*lokiDTOJsonV1Stream
+		for i := range selected {
+			var stream *lokiDTOJsonV1Stream
+			for j := range result {
+				if reflect.DeepEqual(result[j].Stream, selected[i].labels) {
+					stream = result[j]
+				}
+			}
+			if stream == nil {
+				stream = &lokiDTOJsonV1Stream{Stream: selected[i].labels}
+				result = append(result, stream)
+			}
+
+			stream.Values = append(stream.Values, lokiDTOJsonV1Value{
+				Timestamp: strconv.FormatInt(selected[i].timestamp, 10),
+				Line:      selected[i].line,
+			})
+		}
+
+		rawResult, _ := json.Marshal(result)
+		_, _ = fmt.Fprintf(w, `{"status":"success","data":{"resultType":"streams","result":%s}}`, rawResult)
+	}))
+}
+
+func TestQueryClient_QueryRange_Pagination(t *testing.T) {
+	var (
+		streamA = map[string]string{"app": "a"}
+		streamB = map[string]string{"app": "b"}
+		entries []fakeLokiEntry
+	)
+
+	// Several entries share timestamps, so pages are cut in the middle of them
+	for i := 0; i < 10; i++ {
+		entries = append(entries,
+			fakeLokiEntry{labels: streamA, timestamp: int64(100 + i/2), line: fmt.Sprintf("a-%d", i)},
+			fakeLokiEntry{labels: streamB, timestamp: int64(100 + i/2), line: fmt.Sprintf("b-%d", i)},
+		)
+	}
+
+	tests := []struct {
+		name      string
+		limit     int
+		direction Direction
+		wantA     []string
+		wantB     []string
+	}{
+		{
+			name:      "Forward, limit within the data",
+			limit:     7,
+			direction: Forward,
+			wantA:     []string{"a-0", "a-1", "a-2", "a-3"},
+			wantB:     []string{"b-0", "b-1", "b-2"},
+		},
+		{
+			name:      "Backward, limit within the data",
+			limit:     6,
+			direction: Backward,
+			wantA:     []string{"a-6", "a-8", "a-9"},
+			wantB:     []string{"b-6", "b-8", "b-9"},
+		},
+		{
+			name:      "Limit exceeds the data",
+			limit:     100,
+			direction: Forward,
+			wantA:     []string{"a-0", "a-1", "a-2", "a-3", "a-4", "a-5", "a-6", "a-7", "a-8", "a-9"},
+			wantB:     []string{"b-0", "b-1", "b-2", "b-3", "b-4", "b-5", "b-6", "b-7", "b-8", "b-9"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := 0
+			server := newFakeQueryServer(t, entries, &requests)
+			defer server.Close()
+
+			client := NewQueryClient(server.URL)
+			client.SetPageSize(3)
+
+			result, err := client.QueryRange(context.Background(), `{app=~".+"}`,
+				time.Unix(0, 0), time.Unix(0, 1000), tt.limit, tt.direction)
+			if err != nil {
+				t.Fatalf("unexpected error on query: %s", err)
+			}
+
+			if requests < 2 {
+				t.Errorf("result must be fetched page by page, requests: %d", requests)
+			}
+
+			got := map[string][]string{}
+			for _, stream := range result.Streams {
+				for _, entry := range stream.Entries {
+					got[stream.Labels["app"]] = append(got[stream.Labels["app"]], entry.Line)
+				}
+			}
+
+			// Entries sharing a timestamp have no defined order
+			for _, lines := range [][]string{got["a"], got["b"], tt.wantA, tt.wantB} {
+				sort.Strings(lines)
+			}
+
+			if !reflect.DeepEqual(got["a"], tt.wantA) || !reflect.DeepEqual(got["b"], tt.wantB) {
+				t.Errorf("unexpected entries:\n got  = %v\n want = a:%v b:%v", got, tt.wantA, tt.wantB)
+			}
+		})
+	}
+}
+
+func TestQueryClient_Matrix(t *testing.T) {
+	requests := 0
+	server := newFakeQueryServer(t, nil, &requests)
+	defer server.Close()
+
+	result, err := NewQueryClient(server.URL).QueryRange(context.Background(),
+		`rate({app="a"}[1m])`, time.Unix(0, 0), time.Now(), 100, Forward)
+	if err != nil {
+		t.Fatalf("unexpected error on query: %s", err)
+	}
+
+	want := &QueryResult{
+		ResultType: ResultTypeMatrix,
+		Matrix: []*QuerySeries{{
+			Metric: map[string]string{"app": "a"},
+			Values: []QueryPoint{
+				{Timestamp: time.Unix(1588889221, 0), Value: 1.5},
+				{Timestamp: time.Unix(1588889231, 500*int64(time.Millisecond)), Value: 2},
+			},
+		}},
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("unexpected matrix result:\n got  = %+v\n want = %+v", result, want)
+	}
+}
+
+func TestQueryClient_Query(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/loki/api/v1/query" {
+			t.Errorf("unexpected path: %s", r.URL.Path)
+		}
+		if username, password, _ := r.BasicAuth(); username != "user" || password != "secret" {
+			t.Errorf("basic auth is not set")
+		}
+		if r.URL.Query().Get("time") != "1000000000" {
+			t.Errorf("unexpected query time: %s", r.URL.Query().Get("time"))
+		}
+
+		_, _ = fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[`+
+			`{"metric":{"app":"a"},"value":[1588889221,"42"]}]}}`)
+	}))
+	defer server.Close()
+
+	client := NewQueryClient(server.URL)
+	client.SetBasicAuth("user", "secret")
+
+	result, err := client.Query(context.Background(), `count_over_time({app="a"}[1m])`, time.Unix(1, 0))
+	if err != nil {
+		t.Fatalf("unexpected error on query: %s", err)
+	}
+
+	want := []*QuerySample{{
+		Metric:     map[string]string{"app": "a"},
+		QueryPoint: QueryPoint{Timestamp: time.Unix(1588889221, 0), Value: 42},
+	}}
+	if !reflect.DeepEqual(result.Vector, want) {
+		t.Errorf("unexpected vector result:\n got  = %+v\n want = %+v", result.Vector, want)
+	}
+}
+
+func TestQueryClient_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = fmt.Fprint(w, "parse error")
+	}))
+	defer server.Close()
+
+	_, err := NewQueryClient(server.URL).Query(context.Background(), "{", time.Time{})
+
+	if responseErr, ok := err.(*UnexpectedResponseError); !ok || responseErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected bad request error, got: %v", err)
+	}
+}
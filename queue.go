@@ -0,0 +1,102 @@
+package promtail
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+//
+// Defines what happens to a new log entry when the client's queue is full
+//
+type OverflowPolicy struct {
+	strategy overflowStrategy
+	timeout  time.Duration
+}
+
+type overflowStrategy uint8
+
+const (
+	overflowBlock overflowStrategy = iota
+	overflowDropNewest
+	overflowDropOldest
+	overflowBlockWithTimeout
+)
+
+var (
+	// Waits until the queue has room for the entry (default)
+	Block = OverflowPolicy{strategy: overflowBlock}
+	// Drops the entry being added
+	DropNewest = OverflowPolicy{strategy: overflowDropNewest}
+	// Drops the oldest queued entry to make room for the new one
+	DropOldest = OverflowPolicy{strategy: overflowDropOldest}
+)
+
+// Waits until the queue has room for the entry, but no longer than timeout
+func BlockWithTimeout(timeout time.Duration) OverflowPolicy {
+	return OverflowPolicy{strategy: overflowBlockWithTimeout, timeout: timeout}
+}
+
+func (rcv *promtailClient) enqueue(entry packedLogEntry) {
+	if rcv.put(entry) {
+		atomic.AddUint64(&rcv.metrics.entriesEnqueued, 1)
+	}
+}
+
+// Puts the entry into the queue according to the overflow policy, reports whether it's queued
+func (rcv *promtailClient) put(entry packedLogEntry) bool {
+	switch rcv.overflowPolicy.strategy {
+	case overflowDropNewest:
+		select {
+		case rcv.queue <- entry:
+			return true
+		default:
+			rcv.drop(1)
+			return false
+		}
+
+	case overflowDropOldest:
+		for {
+			select {
+			case rcv.queue <- entry:
+				return true
+			default:
+			}
+
+			select {
+			case <-rcv.queue:
+				rcv.drop(1)
+			default:
+			}
+		}
+
+	case overflowBlockWithTimeout:
+		select {
+		case rcv.queue <- entry:
+			return true
+		default:
+		}
+
+		timer := time.NewTimer(rcv.overflowPolicy.timeout)
+		defer timer.Stop()
+
+		select {
+		case rcv.queue <- entry:
+			return true
+		case <-timer.C:
+			rcv.drop(1)
+			return false
+		}
+
+	default:
+		rcv.queue <- entry
+		return true
+	}
+}
+
+func (rcv *promtailClient) drop(entriesNumber uint64) {
+	droppedTotal := atomic.AddUint64(&rcv.droppedEntries, entriesNumber)
+
+	if rcv.dropHandler != nil {
+		rcv.dropHandler(droppedTotal)
+	}
+}
@@ -0,0 +1,144 @@
+// +build unit
+
+package promtail
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+//
+// Streams exchanger which holds the first push until it's released,
+// so the client's queue could be filled up
+//
+type blockingExchanger struct {
+	fakeExchanger
+	pushStarted chan struct{}
+	release     chan struct{}
+	once        sync.Once
+}
+
+func newBlockingExchanger() *blockingExchanger {
+	return &blockingExchanger{
+		pushStarted: make(chan struct{}),
+		release:     make(chan struct{}),
+	}
+}
+
+func (rcv *blockingExchanger) Push(streams []*LogStream) error {
+	rcv.once.Do(func() {
+		close(rcv.pushStarted)
+		<-rcv.release
+	})
+	return rcv.fakeExchanger.Push(streams)
+}
+
+func (rcv *blockingExchanger) pushedFormats() []string {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	var formats []string
+	for i := range rcv.pushed {
+		for j := range rcv.pushed[i] {
+			for k := range rcv.pushed[i][j].Entries {
+				formats = append(formats, rcv.pushed[i][j].Entries[k].Format)
+			}
+		}
+	}
+	return formats
+}
+
+func TestPromtailClient_OverflowPolicy(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      OverflowPolicy
+		wantPushed  []string
+		wantDropped uint64
+	}{
+		{
+			name:        "Drop newest",
+			policy:      DropNewest,
+			wantPushed:  []string{"#1", "#2", "#3"},
+			wantDropped: 1,
+		},
+		{
+			name:        "Drop oldest",
+			policy:      DropOldest,
+			wantPushed:  []string{"#1", "#3", "#4"},
+			wantDropped: 1,
+		},
+		{
+			name:        "Block with timeout",
+			policy:      BlockWithTimeout(10 * time.Millisecond),
+			wantPushed:  []string{"#1", "#2", "#3"},
+			wantDropped: 1,
+		},
+		{
+			name:        "Block",
+			policy:      Block,
+			wantPushed:  []string{"#1", "#2", "#3", "#4"},
+			wantDropped: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				exchanger   = newBlockingExchanger()
+				lastDropped uint64
+			)
+
+			client, err := NewClient(exchanger, nil,
+				WithSendBatchSize(1),
+				WithQueueSize(2),
+				WithOverflowPolicy(tt.policy),
+				WithDropCallback(func(droppedTotal uint64) { lastDropped = droppedTotal }),
+			)
+			if err != nil {
+				t.Fatalf("unexpected error on client initialization: %s", err)
+			}
+
+			// The first entry holds the exchange loop, the next two fill the queue up
+			client.Infof("#1")
+			<-exchanger.pushStarted
+			client.Infof("#2")
+			client.Infof("#3")
+
+			overflowed := make(chan struct{})
+			go func() {
+				client.Infof("#4")
+				close(overflowed)
+			}()
+
+			select {
+			case <-overflowed:
+				if tt.policy == Block {
+					t.Errorf("logging must be blocked while the queue is full")
+				}
+			case <-time.After(100 * time.Millisecond):
+				if tt.policy != Block {
+					t.Errorf("logging must not be blocked while the queue is full")
+				}
+			}
+
+			close(exchanger.release)
+			<-overflowed
+			client.Close()
+
+			got := exchanger.pushedFormats()
+			if len(got) != len(tt.wantPushed) {
+				t.Fatalf("unexpected pushed entries, got: %v, want: %v", got, tt.wantPushed)
+			}
+			for i := range got {
+				if got[i] != tt.wantPushed[i] {
+					t.Fatalf("unexpected pushed entries, got: %v, want: %v", got, tt.wantPushed)
+				}
+			}
+
+			if lastDropped != tt.wantDropped {
+				t.Errorf("unexpected number of dropped entries, got: %d, want: %d", lastDropped, tt.wantDropped)
+			}
+		})
+	}
+}
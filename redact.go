@@ -0,0 +1,218 @@
+package promtail
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+//
+// Describes a kind of secret to be removed from lines, label values and metadata values
+//	NOTE: if the pattern has capture groups, only the first group is replaced,
+//	so a secret could be found by its surroundings (e.g. key name)
+//
+type RedactionRule struct {
+	Name    string
+	Pattern *regexp.Regexp
+	// Optional check of a found secret (e.g. checksum), to avoid false positives
+	Validate func(secret string) bool
+}
+
+var (
+	// JSON Web Tokens, signed or not
+	RedactJWT = RedactionRule{
+		Name:    "jwt",
+		Pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{5,}\.eyJ[A-Za-z0-9_-]{5,}\.[A-Za-z0-9_-]*`),
+	}
+
+	// Tokens of "Authorization: Bearer <token>" headers
+	RedactBearerToken = RedactionRule{
+		Name:    "bearer",
+		Pattern: regexp.MustCompile(`(?i)\bbearer\s+([A-Za-z0-9._~+/-]+=*)`),
+	}
+
+	// AWS access key IDs of users, roles and temporary credentials
+	RedactAWSAccessKey = RedactionRule{
+		Name:    "aws-access-key",
+		Pattern: regexp.MustCompile(`\b(?:AKIA|ASIA|ABIA|ACCA|AGPA|AIDA|AIPA|ANPA|ANVA|APKA|AROA|ASCA)[A-Z0-9]{16}\b`),
+	}
+
+	// AWS secret access keys, found by their key name only, as they have no distinctive format
+	RedactAWSSecretKey = RedactionRule{
+		Name:    "aws-secret-key",
+		Pattern: regexp.MustCompile(`(?i)aws_?secret_?access_?key["']?\s*[:=]\s*["']?([A-Za-z0-9/+]{40})`),
+	}
+
+	// Payment card numbers of 13-19 digits, plain or grouped by spaces or dashes, passing Luhn check
+	//	NOTE: plain numbers are matched by issuer prefixes only (Visa, Mastercard, Amex, Diners,
+	//	JCB, Discover, Maestro, UnionPay), so timestamps and numeric IDs aren't taken for cards
+	RedactCreditCard = RedactionRule{
+		Name: "card",
+		Pattern: regexp.MustCompile(`\b(?:\d{4}(?: \d{4}){2,3}(?: \d{1,3})?|\d{4}(?:-\d{4}){2,3}(?:-\d{1,3})?|` +
+			`3[47]\d{2}[ -]\d{6}[ -]\d{5}|` +
+			`4\d{12}(?:\d{3}){0,2}|5[1-5]\d{14}|5[06-9]\d{11,17}|2[2-7]\d{14}|` +
+			`3[47]\d{13}|3(?:0[0-5]|[689]\d)\d{11,16}|35\d{14,17}|6\d{12,18})\b`),
+		Validate: isLuhnValid,
+	}
+
+	RedactEmail = RedactionRule{
+		Name:    "email",
+		Pattern: regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`),
+	}
+)
+
+//
+// Returns all built-in rules, append custom rules to them to keep the built-ins
+//
+func BuiltinRedactionRules() []RedactionRule {
+	return []RedactionRule{
+		RedactJWT,
+		RedactBearerToken,
+		RedactAWSAccessKey,
+		RedactAWSSecretKey,
+		RedactCreditCard,
+		RedactEmail,
+	}
+}
+
+//
+// Creates a rule from a user-supplied regular expression
+//
+func RedactPattern(name, expr string) (RedactionRule, error) {
+	pattern, err := regexp.Compile(expr)
+	if err != nil {
+		return RedactionRule{}, fmt.Errorf("failed to compile redaction pattern %s: %s", name, err)
+	}
+
+	return RedactionRule{Name: name, Pattern: pattern}, nil
+}
+
+//
+// Defines what a found secret is replaced with
+//
+type RedactionMode struct {
+	hashKey []byte
+}
+
+// Replaces a secret with "[REDACTED:<rule name>]"
+var MaskSecrets = RedactionMode{}
+
+//
+// Replaces a secret with "[REDACTED:<rule name>:<HMAC-SHA256 prefix>]", so entries with
+// the same secret could still be correlated
+//	NOTE: the key keeps low entropy secrets (e.g. card numbers) from being brute-forced by hash
+//
+func HashSecrets(key []byte) RedactionMode {
+	return RedactionMode{hashKey: key}
+}
+
+type redactor struct {
+	mode  RedactionMode
+	rules []RedactionRule
+}
+
+func (rcv *redactor) redact(text string) string {
+	for i := range rcv.rules {
+		text = rcv.apply(rcv.rules[i], text)
+	}
+	return text
+}
+
+func (rcv *redactor) redactValues(values map[string]string) {
+	for key := range values {
+		values[key] = rcv.redact(values[key])
+	}
+}
+
+//
+// Redacts values of the entry's labels, tenant label is kept as it's never sent
+//	NOTE: labels are redacted before validation, as a redacted value may grow
+//	past the label value length limit
+//
+func (rcv *promtailClient) redactLabels(labels map[string]string) {
+	if rcv.redactor == nil {
+		return
+	}
+
+	// Labels are already copied on logging, so it's safe to modify them
+	for key := range labels {
+		if key != rcv.tenantLabel {
+			labels[key] = rcv.redactor.redact(labels[key])
+		}
+	}
+}
+
+func (rcv *redactor) apply(rule RedactionRule, text string) string {
+	matches := rule.Pattern.FindAllStringSubmatchIndex(text, -1)
+	if len(matches) == 0 {
+		return text
+	}
+
+	var (
+		redacted strings.Builder
+		last     = 0
+	)
+
+	for _, match := range matches {
+		start, end := match[0], match[1]
+		if len(match) > 2 && match[2] >= 0 {
+			start, end = match[2], match[3]
+		}
+
+		if rule.Validate != nil && !rule.Validate(text[start:end]) {
+			continue
+		}
+
+		redacted.WriteString(text[last:start])
+		redacted.WriteString(rcv.replacement(rule.Name, text[start:end]))
+		last = end
+	}
+
+	redacted.WriteString(text[last:])
+
+	return redacted.String()
+}
+
+func (rcv *redactor) replacement(name, secret string) string {
+	if len(rcv.mode.hashKey) == 0 {
+		return "[REDACTED:" + name + "]"
+	}
+
+	mac := hmac.New(sha256.New, rcv.mode.hashKey)
+	_, _ = mac.Write([]byte(secret))
+
+	return "[REDACTED:" + name + ":" + hex.EncodeToString(mac.Sum(nil)[:6]) + "]"
+}
+
+//
+// Luhn checksum of a card number, separators are ignored
+//	Read more at: https://en.wikipedia.org/wiki/Luhn_algorithm
+//
+func isLuhnValid(number string) bool {
+	var (
+		sum    = 0
+		digits = 0
+	)
+
+	for i := len(number) - 1; i >= 0; i-- {
+		if number[i] < '0' || number[i] > '9' {
+			continue
+		}
+
+		digit := int(number[i] - '0')
+		if digits%2 == 1 {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+
+		sum += digit
+		digits++
+	}
+
+	return digits >= 13 && sum%10 == 0
+}
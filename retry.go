@@ -0,0 +1,49 @@
+package promtail
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+type retryPolicy struct {
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	maxRetries uint
+}
+
+//
+// Returns a delay before the retry attempt (starting from 0): exponential backoff
+// capped by maxBackoff, where a random half of the delay is a jitter
+//
+func (rcv *retryPolicy) backoff(attempt uint) time.Duration {
+	// Compared before shifting, as the shifted minimum would overflow into a negative delay
+	delay := rcv.maxBackoff
+	if attempt < 63 && rcv.minBackoff <= rcv.maxBackoff>>attempt {
+		delay = rcv.minBackoff << attempt
+	}
+
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+//
+// Server side errors, rate limiting and network errors are worth another try,
+// while other client errors (4xx) would be rejected again
+//
+func isRetryableError(err error) bool {
+	switch typedErr := err.(type) {
+	case *UnexpectedResponseError:
+		return typedErr.StatusCode >= http.StatusInternalServerError ||
+			typedErr.StatusCode == http.StatusTooManyRequests
+
+	case *MultiExchangerError:
+		// Worth another try if any of failed backends could accept the batch later
+		for i := range typedErr.Errors {
+			if typedErr.Errors[i] != nil && isRetryableError(typedErr.Errors[i]) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
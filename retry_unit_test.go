@@ -0,0 +1,182 @@
+// +build unit
+
+package promtail
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPromtailClient_RetryPolicy(t *testing.T) {
+	var (
+		serverErr   = &UnexpectedResponseError{StatusCode: http.StatusServiceUnavailable}
+		rateLimited = &UnexpectedResponseError{StatusCode: http.StatusTooManyRequests}
+		badRequest  = &UnexpectedResponseError{StatusCode: http.StatusBadRequest}
+		networkErr  = errors.New("failed to send push message: connection refused")
+	)
+
+	tests := []struct {
+		name         string
+		failures     []error
+		maxRetries   uint
+		wantAttempts int
+		wantPushed   int
+		wantErrors   int
+	}{
+		{
+			name:         "Recovered after server errors",
+			failures:     []error{serverErr, rateLimited, networkErr},
+			maxRetries:   3,
+			wantAttempts: 4,
+			wantPushed:   1,
+			wantErrors:   0,
+		},
+		{
+			name:         "Retries are exhausted",
+			failures:     []error{serverErr, serverErr, serverErr},
+			maxRetries:   2,
+			wantAttempts: 3,
+			wantPushed:   0,
+			wantErrors:   1,
+		},
+		{
+			name:         "Client errors are not retried",
+			failures:     []error{badRequest},
+			maxRetries:   3,
+			wantAttempts: 1,
+			wantPushed:   0,
+			wantErrors:   1,
+		},
+		{
+			name:         "No retry policy",
+			failures:     []error{serverErr},
+			maxRetries:   0,
+			wantAttempts: 1,
+			wantPushed:   0,
+			wantErrors:   1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				exchanger = &fakeExchanger{failures: tt.failures}
+				errorsNum = 0
+			)
+
+			client, err := NewClient(exchanger, nil,
+				WithSendBatchSize(1),
+				WithRetryPolicy(time.Millisecond, 5*time.Millisecond, tt.maxRetries),
+				WithErrorCallback(func(err error) { errorsNum++ }),
+			)
+			if err != nil {
+				t.Fatalf("unexpected error on client initialization: %s", err)
+			}
+
+			client.Infof("retry me")
+			client.Flush()
+			client.Close()
+
+			if got := exchanger.countAttempts(); got != tt.wantAttempts {
+				t.Errorf("unexpected number of push attempts, got: %d, want: %d", got, tt.wantAttempts)
+			}
+			if got := exchanger.countPushedEntries(); got != tt.wantPushed {
+				t.Errorf("unexpected number of pushed entries, got: %d, want: %d", got, tt.wantPushed)
+			}
+			if errorsNum != tt.wantErrors {
+				t.Errorf("unexpected number of reported errors, got: %d, want: %d", errorsNum, tt.wantErrors)
+			}
+		})
+	}
+}
+
+func TestPromtailClient_RetryPolicy_Close(t *testing.T) {
+	exchanger := &fakeExchanger{}
+	for i := 0; i < 100; i++ {
+		exchanger.failures = append(exchanger.failures, &UnexpectedResponseError{StatusCode: http.StatusServiceUnavailable})
+	}
+
+	client, err := NewClient(exchanger, nil,
+		WithSendBatchSize(1),
+		WithRetryPolicy(time.Second, time.Minute, 10),
+		WithErrorCallback(func(err error) {}),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error on client initialization: %s", err)
+	}
+
+	client.Infof("retry me")
+
+	// Let the push fail and start waiting for the backoff
+	for exchanger.countAttempts() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		client.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("close must not wait for retry backoff")
+	}
+
+	// The last attempt is made on close
+	if got := exchanger.countAttempts(); got != 2 {
+		t.Errorf("unexpected number of push attempts, got: %d, want: %d", got, 2)
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := retryPolicy{
+		minBackoff: 100 * time.Millisecond,
+		maxBackoff: time.Second,
+		maxRetries: 100,
+	}
+
+	for attempt := uint(0); attempt < policy.maxRetries; attempt++ {
+		var (
+			delay    = policy.backoff(attempt)
+			expected = policy.maxBackoff
+		)
+		if attempt < 4 {
+			expected = policy.minBackoff << attempt
+		}
+
+		if delay < expected/2 || delay > expected {
+			t.Fatalf("backoff of attempt %d is out of range [%s, %s]: %s",
+				attempt, expected/2, expected, delay)
+		}
+	}
+}
+
+func TestRetryPolicy_BackoffOverflow(t *testing.T) {
+	tests := []struct {
+		name       string
+		minBackoff time.Duration
+		maxBackoff time.Duration
+	}{
+		{name: "10s minimum", minBackoff: 10 * time.Second, maxBackoff: 5 * time.Minute},
+		{name: "1m minimum", minBackoff: time.Minute, maxBackoff: time.Hour},
+		{name: "1ms minimum", minBackoff: time.Millisecond, maxBackoff: time.Minute},
+		{name: "Minimum above maximum", minBackoff: time.Hour, maxBackoff: time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := retryPolicy{minBackoff: tt.minBackoff, maxBackoff: tt.maxBackoff}
+
+			for _, attempt := range []uint{27, 28, 30, 31, 32, 62, 63, 64, 100, 1 << 20} {
+				if delay := policy.backoff(attempt); delay < tt.maxBackoff/2 || delay > tt.maxBackoff {
+					t.Fatalf("backoff of attempt %d is out of range [%s, %s]: %s",
+						attempt, tt.maxBackoff/2, tt.maxBackoff, delay)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package promtail
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Summary interval used when only rate limits are set
+const defaultSamplingSummaryInterval = time.Minute
+
+// Messages above that number per interval share one counter, so high cardinality
+// templates don't make counters grow without bound
+const (
+	maxSamplingKeys        = 10000
+	samplingOverflowFormat = "(other messages)"
+)
+
+//
+// Suppresses repeated messages and messages above per-level rates, counting what's suppressed
+// so it's reported later with summary entries
+//
+type sampler struct {
+	mu sync.Mutex
+
+	// First entries of each message per interval are kept, then every n-th
+	first      uint64
+	thereafter uint64
+	interval   time.Duration
+
+	counters   map[samplingKey]uint64
+	suppressed map[samplingKey]uint64
+
+	limiters       map[Level]*tokenBucket
+	rateSuppressed map[Level]uint64
+}
+
+// Messages are told apart by format, so entries with different arguments count as one message
+// (prepared messages of writers and adapters are told apart by their template)
+type samplingKey struct {
+	level  Level
+	format string
+}
+
+type samplingSummary struct {
+	level      Level
+	format     string
+	suppressed uint64
+	rateLimit  bool
+}
+
+func newSampler() *sampler {
+	return &sampler{
+		interval:       defaultSamplingSummaryInterval,
+		counters:       make(map[samplingKey]uint64),
+		suppressed:     make(map[samplingKey]uint64),
+		limiters:       make(map[Level]*tokenBucket),
+		rateSuppressed: make(map[Level]uint64),
+	}
+}
+
+//
+// Reports whether the entry should be logged
+//
+func (rcv *sampler) allow(level Level, format string, now time.Time) bool {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	if rcv.first > 0 {
+		key := samplingKey{level: level, format: format}
+		if _, ok := rcv.counters[key]; !ok && len(rcv.counters) >= maxSamplingKeys {
+			key.format = samplingOverflowFormat
+		}
+
+		rcv.counters[key]++
+		counter := rcv.counters[key]
+
+		if counter > rcv.first && (rcv.thereafter == 0 || (counter-rcv.first)%rcv.thereafter != 0) {
+			rcv.suppressed[key]++
+			return false
+		}
+	}
+
+	if limiter, ok := rcv.limiters[level]; ok && !limiter.take(now) {
+		rcv.rateSuppressed[level]++
+		return false
+	}
+
+	return true
+}
+
+//
+// Returns counts of suppressed entries and starts a new sampling interval
+//
+func (rcv *sampler) summarize() []samplingSummary {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	summaries := make([]samplingSummary, 0, len(rcv.suppressed)+len(rcv.rateSuppressed))
+
+	for key, suppressed := range rcv.suppressed {
+		summaries = append(summaries, samplingSummary{level: key.level, format: key.format, suppressed: suppressed})
+	}
+	for level, suppressed := range rcv.rateSuppressed {
+		summaries = append(summaries, samplingSummary{level: level, suppressed: suppressed, rateLimit: true})
+	}
+
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].level != summaries[j].level {
+			return summaries[i].level < summaries[j].level
+		}
+		if summaries[i].rateLimit != summaries[j].rateLimit {
+			return summaries[j].rateLimit
+		}
+		return summaries[i].format < summaries[j].format
+	})
+
+	rcv.counters = make(map[samplingKey]uint64)
+	rcv.suppressed = make(map[samplingKey]uint64)
+	rcv.rateSuppressed = make(map[Level]uint64)
+
+	return summaries
+}
+
+//
+// Classic token bucket: refills with rate tokens per second up to burst, an entry takes one
+//
+type tokenBucket struct {
+	rate      float64
+	burst     float64
+	tokens    float64
+	updatedAt time.Time
+}
+
+func newTokenBucket(rate float64, burst uint) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+	}
+}
+
+func (rcv *tokenBucket) take(now time.Time) bool {
+	if !rcv.updatedAt.IsZero() {
+		rcv.tokens += now.Sub(rcv.updatedAt).Seconds() * rcv.rate
+		if rcv.tokens > rcv.burst {
+			rcv.tokens = rcv.burst
+		}
+	}
+	rcv.updatedAt = now
+
+	if rcv.tokens < 1 {
+		return false
+	}
+
+	rcv.tokens--
+	return true
+}
+
+//
+// Reports whether the entry passes sampling and rate limits, entries are sampled by the template
+// (format of the entry, or message template of a prepared line logged with LogWithTemplate)
+//
+func (rcv *promtailClient) isSampled(level Level, template string) bool {
+	if rcv.sampler == nil {
+		return true
+	}
+
+	if rcv.sampler.allow(level, template, time.Now()) {
+		return true
+	}
+
+	atomic.AddUint64(&rcv.metrics.entriesSuppressed, 1)
+	return false
+}
+
+//
+// Adds entries reporting suppressed ones to the batch, each at the level of suppressed entries
+//
+func (rcv *promtailClient) addSamplingSummaries(batch *logStreamBatch) {
+	for _, summary := range rcv.sampler.summarize() {
+		entry := &LogEntry{
+			Timestamp: time.Now(),
+			Format:    "sampling suppressed %d entries of %q in the last %s",
+			Args:      []interface{}{summary.suppressed, summary.format, rcv.sampler.interval},
+		}
+
+		if summary.rateLimit {
+			entry.Format = "rate limit suppressed %d entries in the last %s"
+			entry.Args = []interface{}{summary.suppressed, rcv.sampler.interval}
+		}
+
+		rcv.addToBatch(batch, packedLogEntry{level: summary.level, logEntry: entry})
+	}
+}
@@ -0,0 +1,250 @@
+// +build unit
+
+package promtail
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_Sampler_Allow(t *testing.T) {
+	tests := []struct {
+		name       string
+		first      uint64
+		thereafter uint64
+		want       []bool
+	}{
+		{
+			name:       "First entries, then every 3rd",
+			first:      2,
+			thereafter: 3,
+			want:       []bool{true, true, false, false, true, false, false, true},
+		},
+		{
+			name:  "First entries only",
+			first: 3,
+			want:  []bool{true, true, true, false, false, false, false, false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSampler()
+			s.first, s.thereafter = tt.first, tt.thereafter
+
+			var got []bool
+			for i := range tt.want {
+				// Arguments don't matter, entries are told apart by format
+				got = append(got, s.allow(Warn, "disk usage is %d%%", time.Now()))
+
+				if !s.allow(Warn, "another message #%d", time.Now()) && uint64(i) < tt.first {
+					t.Errorf("entries of another format must be counted separately")
+				}
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("unexpected sampling:\n got  = %v\n want = %v", got, tt.want)
+			}
+
+			// A new interval starts from scratch
+			s.summarize()
+			if !s.allow(Warn, "disk usage is %d%%", time.Now()) {
+				t.Errorf("first entry of a new interval must be kept")
+			}
+		})
+	}
+}
+
+func Test_Sampler_KeysLimit(t *testing.T) {
+	s := newSampler()
+	s.first = 1
+
+	for i := 0; i < maxSamplingKeys; i++ {
+		if !s.allow(Info, fmt.Sprintf("message #%d", i), time.Now()) {
+			t.Fatalf("first entry of message #%d must be kept", i)
+		}
+	}
+
+	// Messages above the limit share one counter
+	if !s.allow(Info, "one more", time.Now()) || s.allow(Info, "and another", time.Now()) {
+		t.Errorf("messages above the limit must be sampled as one message")
+	}
+	if s.allow(Info, "message #0", time.Now()) {
+		t.Errorf("second entry of a known message must be suppressed")
+	}
+	if len(s.counters) != maxSamplingKeys+1 {
+		t.Errorf("unexpected number of counters: %d, want: %d", len(s.counters), maxSamplingKeys+1)
+	}
+
+	want := []samplingSummary{
+		{level: Info, format: samplingOverflowFormat, suppressed: 1},
+		{level: Info, format: "message #0", suppressed: 1},
+	}
+	if got := s.summarize(); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected summaries:\n got  = %+v\n want = %+v", got, want)
+	}
+}
+
+func Test_TokenBucket(t *testing.T) {
+	var (
+		bucket = newTokenBucket(2, 3)
+		now    = time.Now()
+	)
+
+	for i := 0; i < 3; i++ {
+		if !bucket.take(now) {
+			t.Fatalf("burst entry #%d must be allowed", i)
+		}
+	}
+	if bucket.take(now) {
+		t.Errorf("entry above burst must be suppressed")
+	}
+
+	// 2 tokens per second are refilled
+	now = now.Add(time.Second)
+	if !bucket.take(now) || !bucket.take(now) || bucket.take(now) {
+		t.Errorf("bucket must be refilled with 2 tokens")
+	}
+
+	// Refill never exceeds the burst
+	now = now.Add(time.Hour)
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if bucket.take(now) {
+			allowed++
+		}
+	}
+	if allowed != 3 {
+		t.Errorf("unexpected number of allowed entries after a long pause: %d, want: 3", allowed)
+	}
+}
+
+func TestPromtailClient_Sampling(t *testing.T) {
+	exchanger := &fakeExchanger{}
+
+	client, err := NewClient(exchanger, nil,
+		WithSendBatchTimeout(time.Hour),
+		WithSampling(time.Hour, 2, 0),
+		WithRateLimit(Error, 1, 1),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error on client initialization: %s", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		client.Warnf("hot loop #%d", i)
+	}
+	for i := 0; i < 5; i++ {
+		client.Errorf("failure #%d", i)
+	}
+
+	// Summary of the interval is sent on close
+	client.Close()
+
+	got := map[Level][]string{}
+	for _, streams := range exchanger.pushed {
+		for _, stream := range streams {
+			for _, entry := range stream.Entries {
+				got[stream.Level] = append(got[stream.Level], fmt.Sprintf(entry.Format, entry.Args...))
+			}
+		}
+	}
+
+	want := map[Level][]string{
+		Warn: {
+			"hot loop #0",
+			"hot loop #1",
+			`sampling suppressed 8 entries of "hot loop #%d" in the last 1h0m0s`,
+		},
+		Error: {
+			"failure #0",
+			`sampling suppressed 3 entries of "failure #%d" in the last 1h0m0s`,
+			"rate limit suppressed 1 entries in the last 1h0m0s",
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected pushed entries:\n got  = %q\n want = %q", got, want)
+	}
+
+	if stats := client.Stats(); stats.EntriesSuppressed != 12 {
+		t.Errorf("unexpected number of suppressed entries: %d, want: 12", stats.EntriesSuppressed)
+	}
+
+	if _, err = NewClient(exchanger, nil, WithSampling(0, 1, 1)); err == nil {
+		t.Error("expected error on zero sampling interval, but not occurred")
+	}
+	if _, err = NewClient(exchanger, nil, WithRateLimit(Info, 0, 1)); err == nil {
+		t.Error("expected error on zero rate limit, but not occurred")
+	}
+}
+
+func TestPromtailClient_Sampling_Writer(t *testing.T) {
+	exchanger := &fakeExchanger{}
+
+	client, err := NewClient(exchanger, nil,
+		WithSendBatchTimeout(time.Hour),
+		WithSampling(time.Hour, 1, 0),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error on client initialization: %s", err)
+	}
+
+	// Writer logs prepared lines, so each line is a message on its own
+	writer := client.Writer(Warn, nil)
+	for _, line := range []string{"disk full\n", "user logged in\n", "disk full\n", "connection reset\n"} {
+		if _, err = writer.Write([]byte(line)); err != nil {
+			t.Fatalf("unexpected error on write: %s", err)
+		}
+	}
+
+	client.Close()
+
+	want := []string{
+		"disk full",
+		"user logged in",
+		"connection reset",
+		`sampling suppressed 1 entries of "disk full" in the last 1h0m0s`,
+	}
+	if got := pushedLines(exchanger); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected pushed lines:\n got  = %q\n want = %q", got, want)
+	}
+}
+
+func TestPromtailClient_Sampling_Template(t *testing.T) {
+	exchanger := &fakeExchanger{}
+
+	client, err := NewClient(exchanger, nil,
+		WithSendBatchTimeout(time.Hour),
+		WithSampling(time.Hour, 1, 0),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error on client initialization: %s", err)
+	}
+
+	templateLogger, ok := client.(TemplateLogger)
+	if !ok {
+		t.Fatal("client must support logging with templates")
+	}
+
+	// Prepared lines are sampled by their template, formatted ones by format
+	templateLogger.LogWithTemplate(Info, nil, "payment declined", "payment declined amount=42")
+	templateLogger.LogWithTemplate(Info, nil, "payment declined", "payment declined amount=43")
+	templateLogger.LogWithTemplate(Info, nil, "payment accepted", "payment accepted amount=44")
+	client.Infof("%s", "first")
+	client.Infof("%s", "second")
+
+	client.Close()
+
+	want := []string{
+		"payment declined amount=42",
+		"payment accepted amount=44",
+		"first",
+		`sampling suppressed 1 entries of "%s" in the last 1h0m0s`,
+		`sampling suppressed 1 entries of "payment declined" in the last 1h0m0s`,
+	}
+	if got := pushedLines(exchanger); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected pushed lines:\n got  = %q\n want = %q", got, want)
+	}
+}
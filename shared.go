@@ -1,5 +1,7 @@
 package promtail
 
+import "strings"
+
 func copyLabels(src map[string]string) map[string]string {
 	dst := make(map[string]string, len(src))
 	for i := range src {
@@ -28,3 +30,48 @@ func copyAndMergeLabels(srcs ...map[string]string) map[string]string {
 
 	return dst
 }
+
+func normalizeLokiAddress(lokiAddress string) string {
+	if !(strings.HasPrefix(lokiAddress, "http://") ||
+		strings.HasPrefix(lokiAddress, "https://")) {
+		lokiAddress = "http://" + lokiAddress
+	}
+	return lokiAddress
+}
+
+//
+// Splits streams by tenant, keeping the order of first appearance
+//
+func groupStreamsByTenant(streams []*LogStream) [][]*LogStream {
+	var (
+		groups  [][]*LogStream
+		indexes = make(map[string]int)
+	)
+
+	for i := range streams {
+		if streams[i] == nil {
+			continue
+		}
+
+		index, ok := indexes[streams[i].TenantID]
+		if !ok {
+			index = len(groups)
+			indexes[streams[i].TenantID] = index
+			groups = append(groups, nil)
+		}
+
+		groups[index] = append(groups[index], streams[i])
+	}
+
+	return groups
+}
+
+func countEntries(streams []*LogStream) uint64 {
+	entries := uint64(0)
+	for i := range streams {
+		if streams[i] != nil {
+			entries += uint64(len(streams[i].Entries))
+		}
+	}
+	return entries
+}
@@ -0,0 +1,388 @@
+package promtail
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	spoolSegmentExt     = ".segment"
+	spoolSegmentTempExt = ".tmp"
+	spoolSegmentMagic   = "PTSPOOL1"
+
+	spoolSegmentSequenceDigits = 20
+
+	// Magic + payload length + payload checksum
+	spoolSegmentHeaderSize = len(spoolSegmentMagic) + 4 + 4
+)
+
+var errSpoolSegmentCorrupted = errors.New("spool segment is corrupted")
+
+//
+// Write-ahead spool of batches: every batch is stored in a separate segment
+// file before being pushed and is deleted once Loki accepts it
+//	Segment layout: [magic][payload length: uint32][payload CRC32: uint32][JSON payload]
+//
+type spool struct {
+	directory string
+	maxBytes  int64
+
+	mu       sync.Mutex // Batches are written by concurrent push workers
+	sequence uint64
+	// Segments being delivered right after write, they aren't replayed meanwhile
+	inFlight map[string]struct{}
+	// Set while segments of failed pushes (or previous runs) wait for a replay
+	pending bool
+}
+
+type (
+	spoolStream struct {
+		Level    Level             `json:"level"`
+		Labels   map[string]string `json:"labels"`
+		Entries  []spoolEntry      `json:"entries"`
+		TenantID string            `json:"tenant,omitempty"`
+	}
+
+	spoolEntry struct {
+		Timestamp time.Time         `json:"ts"`
+		Line      string            `json:"line"`
+		Metadata  map[string]string `json:"metadata,omitempty"`
+	}
+)
+
+func newSpool(directory string, maxBytes int64) (*spool, error) {
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %s", err)
+	}
+
+	rcv := &spool{
+		directory: directory,
+		maxBytes:  maxBytes,
+		inFlight:  make(map[string]struct{}),
+	}
+
+	// Leftovers of interrupted writes are never complete
+	tempFiles, _ := filepath.Glob(filepath.Join(directory, "*"+spoolSegmentTempExt))
+	for i := range tempFiles {
+		_ = os.Remove(tempFiles[i])
+	}
+
+	segments, err := rcv.segments()
+	if err != nil {
+		return nil, err
+	}
+
+	rcv.pending = len(segments) > 0
+
+	if len(segments) > 0 {
+		lastSegment := segments[len(segments)-1]
+
+		if rcv.sequence, err = strconv.ParseUint(strings.TrimSuffix(lastSegment, spoolSegmentExt), 10, 64); err != nil {
+			return nil, fmt.Errorf("failed to parse sequence of spool segment %s: %s", lastSegment, err)
+		}
+	}
+
+	return rcv, nil
+}
+
+//
+// Stores streams in a new segment, evicting the oldest segments if the spool is full
+//	NOTE: the segment is in flight until it's released or removed
+//
+func (rcv *spool) write(streams []*LogStream) (segment string, evicted []string, err error) {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	payload, err := json.Marshal(rcv.transformLogStreamsToSpool(streams))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to serialize batch for spool: %s", err)
+	}
+
+	segmentSize := int64(spoolSegmentHeaderSize + len(payload))
+
+	if rcv.maxBytes > 0 {
+		if segmentSize > rcv.maxBytes {
+			return "", nil, fmt.Errorf("batch of %d bytes exceeds spool size limit of %d bytes", segmentSize, rcv.maxBytes)
+		}
+
+		evicted, err = rcv.evict(segmentSize)
+		if err != nil {
+			return "", evicted, err
+		}
+	}
+
+	header := make([]byte, spoolSegmentHeaderSize)
+	copy(header, spoolSegmentMagic)
+	binary.BigEndian.PutUint32(header[len(spoolSegmentMagic):], uint32(len(payload)))
+	binary.BigEndian.PutUint32(header[len(spoolSegmentMagic)+4:], crc32.ChecksumIEEE(payload))
+
+	rcv.sequence++
+	segment = fmt.Sprintf("%0*d%s", spoolSegmentSequenceDigits, rcv.sequence, spoolSegmentExt)
+
+	// Write to a temporary file first, so a crash never leaves a half-written segment
+	tempPath := filepath.Join(rcv.directory, segment+spoolSegmentTempExt)
+
+	file, err := os.OpenFile(tempPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return "", evicted, fmt.Errorf("failed to create spool segment: %s", err)
+	}
+
+	_, err = file.Write(append(header, payload...))
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tempPath, filepath.Join(rcv.directory, segment))
+	}
+	if err != nil {
+		_ = os.Remove(tempPath)
+		return "", evicted, fmt.Errorf("failed to write spool segment: %s", err)
+	}
+
+	rcv.inFlight[segment] = struct{}{}
+
+	return segment, evicted, nil
+}
+
+//
+// Leaves the segment, which isn't in flight anymore, to the next replay
+//
+func (rcv *spool) release(segment string) {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	delete(rcv.inFlight, segment)
+	rcv.pending = true
+}
+
+func (rcv *spool) isPending() bool {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	return rcv.pending
+}
+
+//
+// Returns segments waiting for a replay (not in flight), the oldest goes first,
+// the spool stops being pending once there are none
+//
+func (rcv *spool) pendingSegments() ([]string, error) {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	segments, err := rcv.segments()
+	if err != nil {
+		return nil, err
+	}
+
+	pending := segments[:0]
+	for i := range segments {
+		if _, ok := rcv.inFlight[segments[i]]; !ok {
+			pending = append(pending, segments[i])
+		}
+	}
+
+	rcv.pending = len(pending) > 0
+
+	return pending, nil
+}
+
+func (rcv *spool) read(segment string) ([]*LogStream, error) {
+	raw, err := ioutil.ReadFile(filepath.Join(rcv.directory, segment))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool segment: %s", err)
+	}
+
+	if len(raw) < spoolSegmentHeaderSize || !bytes.HasPrefix(raw, []byte(spoolSegmentMagic)) {
+		return nil, errSpoolSegmentCorrupted
+	}
+
+	var (
+		payloadLength   = binary.BigEndian.Uint32(raw[len(spoolSegmentMagic):])
+		payloadChecksum = binary.BigEndian.Uint32(raw[len(spoolSegmentMagic)+4:])
+		payload         = raw[spoolSegmentHeaderSize:]
+	)
+
+	if uint32(len(payload)) != payloadLength || crc32.ChecksumIEEE(payload) != payloadChecksum {
+		return nil, errSpoolSegmentCorrupted
+	}
+
+	var spoolStreams []*spoolStream
+	if err = json.Unmarshal(payload, &spoolStreams); err != nil {
+		return nil, errSpoolSegmentCorrupted
+	}
+
+	return rcv.transformSpoolToLogStreams(spoolStreams), nil
+}
+
+func (rcv *spool) remove(segment string) error {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	delete(rcv.inFlight, segment)
+
+	return rcv.removeSegment(segment)
+}
+
+func (rcv *spool) removeSegment(segment string) error {
+	if err := os.Remove(filepath.Join(rcv.directory, segment)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove spool segment: %s", err)
+	}
+	return nil
+}
+
+//
+// Returns names of stored segments, the oldest goes first
+//
+func (rcv *spool) segments() ([]string, error) {
+	files, err := ioutil.ReadDir(rcv.directory)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list spool directory: %s", err)
+	}
+
+	segments := make([]string, 0, len(files))
+	for i := range files {
+		if !files[i].IsDir() && isSpoolSegmentName(files[i].Name()) {
+			segments = append(segments, files[i].Name())
+		}
+	}
+	sort.Strings(segments)
+
+	return segments, nil
+}
+
+//
+// Segments are named by a zero-padded sequence number, so they are sorted by age,
+// other files (e.g. left by someone else) are never touched
+//
+func isSpoolSegmentName(name string) bool {
+	sequence := strings.TrimSuffix(name, spoolSegmentExt)
+	if len(sequence) != spoolSegmentSequenceDigits || len(sequence) == len(name) {
+		return false
+	}
+
+	for i := 0; i < len(sequence); i++ {
+		if sequence[i] < '0' || sequence[i] > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+//
+// Removes the oldest segments until a new segment of given size fits into the spool
+//
+func (rcv *spool) evict(size int64) ([]string, error) {
+	files, err := ioutil.ReadDir(rcv.directory)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list spool directory: %s", err)
+	}
+
+	var (
+		totalSize int64
+		segments  = make([]os.FileInfo, 0, len(files))
+		evicted   []string
+	)
+
+	for i := range files {
+		if !files[i].IsDir() && isSpoolSegmentName(files[i].Name()) {
+			segments = append(segments, files[i])
+			totalSize += files[i].Size()
+		}
+	}
+
+	sort.Slice(segments, func(i, j int) bool { return segments[i].Name() < segments[j].Name() })
+
+	for i := 0; i < len(segments) && totalSize+size > rcv.maxBytes; i++ {
+		if err = rcv.removeSegment(segments[i].Name()); err != nil {
+			return evicted, err
+		}
+
+		totalSize -= segments[i].Size()
+		evicted = append(evicted, segments[i].Name())
+	}
+
+	return evicted, nil
+}
+
+//
+// Entries are stored already formatted, so arguments don't need to be serializable
+//
+func (rcv *spool) transformLogStreamsToSpool(streams []*LogStream) []*spoolStream {
+	spoolStreams := make([]*spoolStream, 0, len(streams))
+
+	for i := range streams {
+		if streams[i] == nil || len(streams[i].Entries) == 0 {
+			continue
+		}
+
+		stream := &spoolStream{
+			Level:    streams[i].Level,
+			Labels:   streams[i].Labels,
+			Entries:  make([]spoolEntry, 0, len(streams[i].Entries)),
+			TenantID: streams[i].TenantID,
+		}
+
+		for j := range streams[i].Entries {
+			if streams[i].Entries[j] == nil {
+				continue
+			}
+
+			stream.Entries = append(stream.Entries, spoolEntry{
+				Timestamp: streams[i].Entries[j].Timestamp,
+				Line:      fmt.Sprintf(streams[i].Entries[j].Format, streams[i].Entries[j].Args...),
+				Metadata:  streams[i].Entries[j].Metadata,
+			})
+		}
+
+		spoolStreams = append(spoolStreams, stream)
+	}
+
+	return spoolStreams
+}
+
+func (rcv *spool) transformSpoolToLogStreams(spoolStreams []*spoolStream) []*LogStream {
+	streams := make([]*LogStream, 0, len(spoolStreams))
+
+	for i := range spoolStreams {
+		if spoolStreams[i] == nil {
+			continue
+		}
+
+		stream := &LogStream{
+			Level:    spoolStreams[i].Level,
+			Labels:   spoolStreams[i].Labels,
+			Entries:  make([]*LogEntry, 0, len(spoolStreams[i].Entries)),
+			TenantID: spoolStreams[i].TenantID,
+		}
+
+		for j := range spoolStreams[i].Entries {
+			stream.Entries = append(stream.Entries, &LogEntry{
+				Timestamp: spoolStreams[i].Entries[j].Timestamp,
+				Format:    "%s",
+				Args:      []interface{}{spoolStreams[i].Entries[j].Line},
+				Metadata:  spoolStreams[i].Entries[j].Metadata,
+			})
+		}
+
+		streams = append(streams, stream)
+	}
+
+	return streams
+}
@@ -0,0 +1,423 @@
+// +build unit
+
+package promtail
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newTestSpoolDirectory(t *testing.T) string {
+	directory, err := ioutil.TempDir("", "promtail-spool")
+	if err != nil {
+		t.Fatalf("unable to create spool directory: %s", err)
+	}
+	return directory
+}
+
+func newTestStreams(entriesNumber int) []*LogStream {
+	stream := newLeveledStream(Error, map[string]string{"instanceId": "instance-a1"})
+	for i := 0; i < entriesNumber; i++ {
+		stream.Entries = append(stream.Entries, &LogEntry{
+			Timestamp: time.Unix(0, int64(i)),
+			Format:    "entry #%d, 100%% %s",
+			Args:      []interface{}{i, "done"},
+		})
+	}
+	return []*LogStream{stream}
+}
+
+func TestSpool_WriteRead(t *testing.T) {
+	directory := newTestSpoolDirectory(t)
+	defer os.RemoveAll(directory)
+
+	spool, err := newSpool(directory, 0)
+	if err != nil {
+		t.Fatalf("unexpected error on spool initialization: %s", err)
+	}
+
+	segment, _, err := spool.write(newTestStreams(3))
+	if err != nil {
+		t.Fatalf("unexpected error on spool write: %s", err)
+	}
+
+	streams, err := spool.read(segment)
+	if err != nil {
+		t.Fatalf("unexpected error on spool read: %s", err)
+	}
+
+	var (
+		exchanger = NewJSONv1Exchanger("loki").(*lokiJsonV1Exchanger)
+		got       = exchanger.transformLogStreamsToDTO(streams)
+		want      = exchanger.transformLogStreamsToDTO(newTestStreams(3))
+	)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("restored streams don't match the written ones")
+	}
+
+	// Tenant is kept to push the batch on behalf of the same tenant after restart
+	tenantStreams := newTestStreams(1)
+	tenantStreams[0].TenantID = "team-a"
+
+	tenantSegment, _, err := spool.write(tenantStreams)
+	if err != nil {
+		t.Fatalf("unexpected error on spool write: %s", err)
+	}
+	if streams, err = spool.read(tenantSegment); err != nil || streams[0].TenantID != "team-a" {
+		t.Errorf("tenant is not restored, got: %+v, error: %v", streams, err)
+	}
+	_ = spool.remove(tenantSegment)
+
+	// Levels are stored as numbers, custom levels included
+	customStreams := []*LogStream{newLeveledStream(Level(42))}
+	customStreams[0].Entries = []*LogEntry{{Timestamp: time.Unix(0, 1), Format: "custom"}}
+
+	customSegment, _, err := spool.write(customStreams)
+	if err != nil {
+		t.Fatalf("unexpected error on spool write of custom level: %s", err)
+	}
+	if raw, _ := ioutil.ReadFile(filepath.Join(directory, customSegment)); !bytes.Contains(raw, []byte(`"level":42`)) {
+		t.Errorf("level must be stored as a number, got: %s", raw)
+	}
+	if streams, err = spool.read(customSegment); err != nil || streams[0].Level != Level(42) {
+		t.Errorf("custom level is not restored, got: %+v, error: %v", streams, err)
+	}
+	_ = spool.remove(customSegment)
+
+	// Sequence continues after restart
+	restarted, err := newSpool(directory, 0)
+	if err != nil {
+		t.Fatalf("unexpected error on spool initialization: %s", err)
+	}
+
+	next, _, err := restarted.write(newTestStreams(1))
+	if err != nil {
+		t.Fatalf("unexpected error on spool write: %s", err)
+	}
+	if next <= segment {
+		t.Errorf("segment written after restart must be newer, got: %s, previous: %s", next, segment)
+	}
+}
+
+func TestSpool_Corrupted(t *testing.T) {
+	directory := newTestSpoolDirectory(t)
+	defer os.RemoveAll(directory)
+
+	spool, _ := newSpool(directory, 0)
+
+	tests := []struct {
+		name    string
+		corrupt func(raw []byte) []byte
+	}{
+		{name: "Truncated payload", corrupt: func(raw []byte) []byte { return raw[:len(raw)-5] }},
+		{name: "Truncated header", corrupt: func(raw []byte) []byte { return raw[:5] }},
+		{name: "Flipped byte", corrupt: func(raw []byte) []byte { raw[len(raw)-3] ^= 0xff; return raw }},
+		{name: "Empty file", corrupt: func(raw []byte) []byte { return nil }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			segment, _, _ := spool.write(newTestStreams(2))
+			path := filepath.Join(directory, segment)
+
+			raw, _ := ioutil.ReadFile(path)
+			_ = ioutil.WriteFile(path, tt.corrupt(raw), 0644)
+
+			if _, err := spool.read(segment); err != errSpoolSegmentCorrupted {
+				t.Errorf("expected corruption error, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestSpool_SegmentNames(t *testing.T) {
+	tests := []struct {
+		name         string
+		files        []string
+		wantSegments []string
+		wantNext     string
+		wantErr      bool
+	}{
+		{
+			name:         "Stray files are skipped",
+			files:        []string{"00000000000000000007.segment", "notes.segment", "7.segment", "0000000000000000000x.segment", "README"},
+			wantSegments: []string{"00000000000000000007.segment"},
+			wantNext:     "00000000000000000008.segment",
+		},
+		{
+			name:    "Sequence out of range",
+			files:   []string{"99999999999999999999.segment"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			directory := newTestSpoolDirectory(t)
+			defer os.RemoveAll(directory)
+
+			for _, file := range tt.files {
+				if err := ioutil.WriteFile(filepath.Join(directory, file), nil, 0644); err != nil {
+					t.Fatalf("unable to create file: %s", err)
+				}
+			}
+
+			spool, err := newSpool(directory, 0)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v, want error: %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if segments, _ := spool.segments(); !reflect.DeepEqual(segments, tt.wantSegments) {
+				t.Errorf("unexpected segments, got: %v, want: %v", segments, tt.wantSegments)
+			}
+
+			// New segments go after stored ones
+			if segment, _, err := spool.write(newTestStreams(1)); err != nil || segment != tt.wantNext {
+				t.Errorf("unexpected new segment: %s, error: %v, want: %s", segment, err, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestSpool_Eviction(t *testing.T) {
+	directory := newTestSpoolDirectory(t)
+	defer os.RemoveAll(directory)
+
+	unlimited, _ := newSpool(directory, 0)
+	first, _, _ := unlimited.write(newTestStreams(10))
+	info, _ := os.Stat(filepath.Join(directory, first))
+
+	// Room for two segments only
+	spool, _ := newSpool(directory, 2*info.Size()+1)
+
+	second, evicted, _ := spool.write(newTestStreams(10))
+	if len(evicted) != 0 {
+		t.Errorf("unexpected eviction, evicted: %v", evicted)
+	}
+
+	_, evicted, _ = spool.write(newTestStreams(10))
+	if !reflect.DeepEqual(evicted, []string{first}) {
+		t.Errorf("the oldest segment must be evicted, got: %v", evicted)
+	}
+
+	segments, _ := spool.segments()
+	if len(segments) != 2 || segments[0] != second {
+		t.Errorf("unexpected segments after eviction: %v", segments)
+	}
+
+	if _, _, err := spool.write(newTestStreams(1000)); err == nil {
+		t.Errorf("expected error on batch exceeding the spool limit, but not occurred")
+	}
+}
+
+func TestPromtailClient_SpoolReplay(t *testing.T) {
+	directory := newTestSpoolDirectory(t)
+	defer os.RemoveAll(directory)
+
+	//
+	// Leave undelivered and corrupted batches from a "previous run"
+	//
+
+	spool, _ := newSpool(directory, 0)
+	_, _, _ = spool.write(newTestStreams(3))
+	corrupted, _, _ := spool.write(newTestStreams(3))
+	_, _, _ = spool.write(newTestStreams(2))
+	_ = ioutil.WriteFile(filepath.Join(directory, corrupted), []byte("garbage"), 0644)
+
+	var (
+		exchanger = &fakeExchanger{}
+		errorsNum = 0
+	)
+
+	client, err := NewClient(exchanger, nil,
+		WithSpoolDirectory(directory, 0),
+		WithErrorCallback(func(err error) { errorsNum++ }),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error on client initialization: %s", err)
+	}
+	client.Close()
+
+	if got := exchanger.countPushedEntries(); got != 5 {
+		t.Errorf("unexpected number of replayed entries, got: %d, want: %d", got, 5)
+	}
+	if errorsNum != 1 {
+		t.Errorf("corrupted segment must be reported once, reported: %d", errorsNum)
+	}
+	if segments, _ := spool.segments(); len(segments) != 0 {
+		t.Errorf("spool must be empty after replay, got: %v", segments)
+	}
+}
+
+func TestPromtailClient_SpoolKeepsUndelivered(t *testing.T) {
+	directory := newTestSpoolDirectory(t)
+	defer os.RemoveAll(directory)
+
+	exchanger := &fakeExchanger{
+		failures: []error{&UnexpectedResponseError{StatusCode: 503}},
+	}
+
+	client, err := NewClient(exchanger, nil,
+		WithSpoolDirectory(directory, 0),
+		WithErrorCallback(func(err error) {}),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error on client initialization: %s", err)
+	}
+
+	client.Infof("keep me")
+	client.Close()
+
+	spool, _ := newSpool(directory, 0)
+	if segments, _ := spool.segments(); len(segments) != 1 {
+		t.Fatalf("undelivered batch must stay in the spool, got: %v", segments)
+	}
+
+	// Next run delivers it
+	client, _ = NewClient(exchanger, nil, WithSpoolDirectory(directory, 0))
+	client.Close()
+
+	if got := exchanger.countPushedEntries(); got != 1 {
+		t.Errorf("unexpected number of replayed entries, got: %d, want: %d", got, 1)
+	}
+}
+
+func TestPromtailClient_SpoolReplayWhileRunning(t *testing.T) {
+	tests := []struct {
+		name     string
+		nextPush bool
+	}{
+		{name: "Replay before the next push", nextPush: true},
+		{name: "Replay on idle batch timeout", nextPush: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			directory := newTestSpoolDirectory(t)
+			defer os.RemoveAll(directory)
+
+			exchanger := &fakeExchanger{
+				failures: []error{&UnexpectedResponseError{StatusCode: 503}},
+			}
+
+			client, err := NewClient(exchanger, nil,
+				WithSpoolDirectory(directory, 0),
+				WithSendBatchSize(1),
+				WithSendBatchTimeout(50*time.Millisecond),
+				WithErrorCallback(func(err error) {}),
+			)
+			if err != nil {
+				t.Fatalf("unexpected error on client initialization: %s", err)
+			}
+			defer client.Close()
+
+			client.Infof("first")
+			client.Flush()
+
+			if tt.nextPush {
+				client.Infof("second")
+				client.Flush()
+			} else {
+				time.Sleep(200 * time.Millisecond)
+				client.Flush()
+			}
+
+			want := []string{"first"}
+			if tt.nextPush {
+				want = append(want, "second")
+			}
+
+			// The failed batch is pushed before newer ones
+			if got := pushedLines(exchanger); !reflect.DeepEqual(got, want) {
+				t.Errorf("unexpected pushed lines, got: %v, want: %v", got, want)
+			}
+
+			spool, _ := newSpool(directory, 0)
+			if segments, _ := spool.segments(); len(segments) != 0 {
+				t.Errorf("spool must be empty after replay, got: %v", segments)
+			}
+		})
+	}
+}
+
+func TestPromtailClient_SpoolReplayWithWorkers(t *testing.T) {
+	const (
+		streamsNum = 8
+		entriesNum = 400
+	)
+
+	directory := newTestSpoolDirectory(t)
+	defer os.RemoveAll(directory)
+
+	// Failed pushes are interleaved with successful ones, so replays overlap with pushes of other workers
+	exchanger := &fakeExchanger{latency: time.Millisecond}
+	for i := 0; i < 20; i++ {
+		exchanger.failures = append(exchanger.failures, &UnexpectedResponseError{StatusCode: 503}, nil, nil)
+	}
+
+	client, err := NewClient(exchanger, nil,
+		WithSpoolDirectory(directory, 0),
+		WithConcurrency(4),
+		WithSendBatchSize(streamsNum),
+		WithSendBatchTimeout(10*time.Millisecond),
+		WithErrorCallback(func(err error) {}),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error on client initialization: %s", err)
+	}
+	defer client.Close()
+
+	for i := 0; i < entriesNum; i++ {
+		client.LogfWithLabels(Info, map[string]string{"stream": strconv.Itoa(i % streamsNum)}, "%d", i)
+	}
+	client.Flush()
+
+	// Batches of the last failed pushes are replayed on idle batch timeout
+	spool, _ := newSpool(directory, 0)
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if segments, _ := spool.segments(); len(segments) == 0 {
+			break
+		}
+	}
+	client.Flush()
+
+	exchanger.mu.Lock()
+	defer exchanger.mu.Unlock()
+
+	var (
+		delivered = make(map[string]int)
+		lastSeen  = make(map[string]int)
+	)
+
+	for i := range exchanger.pushed {
+		for _, stream := range exchanger.pushed[i] {
+			for _, entry := range stream.Entries {
+				line := fmt.Sprintf(entry.Format, entry.Args...)
+				delivered[line]++
+
+				// Entries of a stream are pushed in order, even if some of them are replayed
+				number, _ := strconv.Atoi(line)
+				if last, ok := lastSeen[stream.Labels["stream"]]; ok && number < last {
+					t.Errorf("entry %d of stream %s is pushed after entry %d", number, stream.Labels["stream"], last)
+				}
+				lastSeen[stream.Labels["stream"]] = number
+			}
+		}
+	}
+
+	for i := 0; i < entriesNum; i++ {
+		if count := delivered[strconv.Itoa(i)]; count != 1 {
+			t.Errorf("entry %d is pushed %d times, want once", i, count)
+		}
+	}
+}
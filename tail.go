@@ -0,0 +1,263 @@
+package promtail
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/ic2hrmk/promtail/internal/websocket"
+)
+
+const (
+	tailMinReconnectBackoff = 500 * time.Millisecond
+	tailMaxReconnectBackoff = 30 * time.Second
+
+	tailResponsesBufferSize = 16
+)
+
+type TailResponse struct {
+	// Level isn't transferred by Loki, so streams carry raw lines as entries
+	// with "%s" format and the line as the only argument
+	Streams []*LogStream
+	// Entries, skipped by Loki because the connection wasn't able to keep up
+	DroppedEntries []*TailDroppedEntry
+	// Reconnect error, which has stopped tailing, it comes in the last response
+	Err error
+}
+
+type TailDroppedEntry struct {
+	Labels    map[string]string
+	Timestamp time.Time
+}
+
+//
+//	Data transfer objects are restored from `tail API` description:
+//		https://github.com/grafana/loki/blob/master/docs/api.md#get-lokiapiv1tail
+//	{
+//		"streams": [ { "stream": {"label": "value"}, "values": [ [ "<unix epoch in nanoseconds>", "<log line>" ] ] } ],
+//		"dropped_entries": [ { "labels": {"label": "value"}, "timestamp": "<unix epoch in nanoseconds>" } ]
+//	}
+//
+type (
+	lokiDTOJsonV1TailResponse struct {
+		Streams        []*lokiDTOJsonV1Stream         `json:"streams"`
+		DroppedEntries []*lokiDTOJsonV1DroppedEntries `json:"dropped_entries"`
+	}
+
+	lokiDTOJsonV1DroppedEntries struct {
+		Labels    map[string]string `json:"labels"`
+		Timestamp string            `json:"timestamp"`
+	}
+)
+
+//
+// Follows logs matching the selector in real time via Loki's tail WebSocket API.
+// Delivered entries are delayed by delayFor (up to 5s, so late entries are not missed),
+// start is the time to replay entries from (zero time stands for now).
+//
+// Disconnects are followed by reconnects resuming from the last seen timestamp,
+// the channel is closed once the context is done.
+//	NOTE: only the first connection error is returned, later ones are retried unless
+//	they're permanent (e.g. 400, 401, 403), such error comes in the last response's Err
+//
+func (rcv *QueryClient) Tail(
+	ctx context.Context, selector string, delayFor time.Duration, start time.Time,
+) (<-chan *TailResponse, error) {
+	tailer := &tailSession{
+		client:   rcv,
+		selector: selector,
+		delayFor: delayFor,
+		start:    start,
+		backoff: retryPolicy{
+			minBackoff: tailMinReconnectBackoff,
+			maxBackoff: tailMaxReconnectBackoff,
+		},
+		responses: make(chan *TailResponse, tailResponsesBufferSize),
+	}
+
+	conn, err := tailer.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	go tailer.run(ctx, conn)
+
+	return tailer.responses, nil
+}
+
+type tailSession struct {
+	client   *QueryClient
+	selector string
+	delayFor time.Duration
+	start    time.Time
+	backoff  retryPolicy
+
+	// Entries of the last seen timestamp, they come again after reconnect
+	boundary  queryPageBoundary
+	responses chan *TailResponse
+}
+
+func (rcv *tailSession) run(ctx context.Context, conn *websocket.Conn) {
+	defer close(rcv.responses)
+
+	for {
+		rcv.consume(ctx, conn)
+
+		for attempt := uint(0); ; attempt++ {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(rcv.backoff.backoff(attempt)):
+			}
+
+			var err error
+			if conn, err = rcv.connect(ctx); err == nil {
+				break
+			}
+
+			if !isRetryableError(err) {
+				select {
+				case rcv.responses <- &TailResponse{Err: err}:
+				case <-ctx.Done():
+				}
+				return
+			}
+		}
+	}
+}
+
+// Reads the connection until it's broken or the context is done
+func (rcv *tailSession) consume(ctx context.Context, conn *websocket.Conn) {
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		_ = conn.Close()
+	}()
+
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		tailResponse := &lokiDTOJsonV1TailResponse{}
+		if err = json.Unmarshal(message, tailResponse); err != nil {
+			continue
+		}
+
+		response := rcv.transformDTOToTailResponse(tailResponse)
+		if len(response.Streams) == 0 && len(response.DroppedEntries) == 0 {
+			continue
+		}
+
+		select {
+		case rcv.responses <- response:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (rcv *tailSession) connect(ctx context.Context) (*websocket.Conn, error) {
+	params := url.Values{
+		"query":     {rcv.selector},
+		"delay_for": {strconv.Itoa(int(rcv.delayFor / time.Second))},
+	}
+
+	// The last seen timestamp is requested again as several entries may share it
+	if !rcv.boundary.timestamp.IsZero() {
+		params.Set("start", strconv.FormatInt(rcv.boundary.timestamp.UnixNano(), 10))
+	} else if !rcv.start.IsZero() {
+		params.Set("start", strconv.FormatInt(rcv.start.UnixNano(), 10))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		rcv.client.lokiAddress+"/loki/api/v1/tail?"+params.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %s", err)
+	}
+
+	if rcv.client.tenantID != "" {
+		req.Header.Set(tenantHeader, rcv.client.tenantID)
+	}
+
+	if err = rcv.client.authenticate(req); err != nil {
+		return nil, err
+	}
+
+	conn, err := websocket.Dial(rcv.client.restClient, req)
+	if err != nil {
+		if handshakeErr, ok := err.(*websocket.HandshakeError); ok {
+			return nil, &UnexpectedResponseError{
+				StatusCode: handshakeErr.StatusCode,
+				Message:    handshakeErr.Message,
+			}
+		}
+		return nil, fmt.Errorf("failed to connect tail: %s", err)
+	}
+
+	return conn, nil
+}
+
+func (rcv *tailSession) transformDTOToTailResponse(tailResponse *lokiDTOJsonV1TailResponse) *TailResponse {
+	response := &TailResponse{}
+	seen := rcv.boundary
+
+	for i := range tailResponse.Streams {
+		stream := &LogStream{
+			Labels:  tailResponse.Streams[i].Stream,
+			Entries: make([]*LogEntry, 0, len(tailResponse.Streams[i].Values)),
+		}
+
+		for j := range tailResponse.Streams[i].Values {
+			value := &tailResponse.Streams[i].Values[j]
+
+			timestamp, err := strconv.ParseInt(value.Timestamp, 10, 64)
+			if err != nil {
+				continue
+			}
+
+			entry := &QueryEntry{Timestamp: time.Unix(0, timestamp), Line: value.Line}
+
+			// Entries of the last seen timestamp are replayed after reconnect
+			if seen.contains(stream.Labels, entry) {
+				continue
+			}
+			rcv.boundary.track(stream.Labels, entry, Forward)
+
+			stream.Entries = append(stream.Entries, &LogEntry{
+				Timestamp: entry.Timestamp,
+				Format:    "%s",
+				Args:      []interface{}{value.Line},
+				Metadata:  value.Metadata,
+			})
+		}
+
+		if len(stream.Entries) > 0 {
+			response.Streams = append(response.Streams, stream)
+		}
+	}
+
+	for i := range tailResponse.DroppedEntries {
+		timestamp, err := strconv.ParseInt(tailResponse.DroppedEntries[i].Timestamp, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		response.DroppedEntries = append(response.DroppedEntries, &TailDroppedEntry{
+			Labels:    tailResponse.DroppedEntries[i].Labels,
+			Timestamp: time.Unix(0, timestamp),
+		})
+	}
+
+	return response
+}
@@ -0,0 +1,217 @@
+// +build unit
+
+package promtail
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ic2hrmk/promtail/internal/websocket"
+)
+
+//
+// Serves tail connections, each one gets the next batch of messages and is closed afterwards
+//
+func newFakeTailServer(connections [][]*lokiDTOJsonV1TailResponse, starts *[]string) *httptest.Server {
+	var (
+		mu      sync.Mutex
+		counter int
+	)
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		current := counter
+		counter++
+		*starts = append(*starts, r.URL.Query().Get("start"))
+		mu.Unlock()
+
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+
+		if current >= len(connections) {
+			// Keeps the last connection open until the client leaves
+			_, _, _ = conn.ReadMessage()
+			return
+		}
+
+		for _, message := range connections[current] {
+			payload, _ := json.Marshal(message)
+			_ = conn.WriteMessage(websocket.OpText, payload)
+		}
+	}))
+}
+
+func tailMessage(labels map[string]string, values ...string) *lokiDTOJsonV1TailResponse {
+	stream := &lokiDTOJsonV1Stream{Stream: labels}
+	for i := 0; i+1 < len(values); i += 2 {
+		stream.Values = append(stream.Values, lokiDTOJsonV1Value{Timestamp: values[i], Line: values[i+1]})
+	}
+	return &lokiDTOJsonV1TailResponse{Streams: []*lokiDTOJsonV1Stream{stream}}
+}
+
+func TestQueryClient_Tail(t *testing.T) {
+	var (
+		app    = map[string]string{"app": "a"}
+		starts []string
+	)
+
+	server := newFakeTailServer([][]*lokiDTOJsonV1TailResponse{
+		{
+			tailMessage(app, "100", "first"),
+			tailMessage(app, "200", "second", "200", "third"),
+			{DroppedEntries: []*lokiDTOJsonV1DroppedEntries{{Labels: app, Timestamp: "150"}}},
+		},
+		{
+			// Resumed from the last seen timestamp, so its entries come again
+			tailMessage(app, "200", "second", "200", "third", "200", "fourth", "300", "fifth"),
+		},
+	}, &starts)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	responses, err := NewQueryClient(server.URL).Tail(ctx, `{app="a"}`, 2*time.Second, time.Unix(0, 50))
+	if err != nil {
+		t.Fatalf("Tail() error = %s", err)
+	}
+
+	var (
+		lines   []string
+		dropped []*TailDroppedEntry
+		timeout = time.After(10 * time.Second)
+	)
+
+	for len(lines) < 5 {
+		select {
+		case response := <-responses:
+			for _, stream := range response.Streams {
+				if !reflect.DeepEqual(stream.Labels, app) {
+					t.Errorf("unexpected stream labels: %v", stream.Labels)
+				}
+				for _, entry := range stream.Entries {
+					lines = append(lines, strconv.FormatInt(entry.Timestamp.UnixNano(), 10)+" "+entry.Args[0].(string))
+				}
+			}
+			dropped = append(dropped, response.DroppedEntries...)
+		case <-timeout:
+			t.Fatalf("timed out, received lines: %v", lines)
+		}
+	}
+
+	expectedLines := []string{"100 first", "200 second", "200 third", "200 fourth", "300 fifth"}
+	if !reflect.DeepEqual(lines, expectedLines) {
+		t.Errorf("received lines = %v, want %v", lines, expectedLines)
+	}
+
+	expectedDropped := []*TailDroppedEntry{{Labels: app, Timestamp: time.Unix(0, 150)}}
+	if !reflect.DeepEqual(dropped, expectedDropped) {
+		t.Errorf("dropped entries = %v, want %v", dropped, expectedDropped)
+	}
+
+	cancel()
+	for range responses {
+	}
+
+	if expectedStarts := []string{"50", "200"}; len(starts) < 2 || !reflect.DeepEqual(starts[:2], expectedStarts) {
+		t.Errorf("requested starts = %v, want prefix %v", starts, expectedStarts)
+	}
+}
+
+func TestQueryClient_TailRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "parse error", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	_, err := NewQueryClient(server.URL).Tail(context.Background(), `{app=`, 0, time.Time{})
+
+	responseErr, ok := err.(*UnexpectedResponseError)
+	if !ok || responseErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("Tail() error = %v, want unexpected response error with 400 status", err)
+	}
+}
+
+func TestQueryClient_TailReconnectRejected(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		counter int
+	)
+
+	// The first connection is served and closed, reconnects are unauthorized
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		current := counter
+		counter++
+		mu.Unlock()
+
+		if current > 0 {
+			http.Error(w, "no org id", http.StatusUnauthorized)
+			return
+		}
+
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+
+		payload, _ := json.Marshal(tailMessage(map[string]string{"app": "a"}, "100", "first"))
+		_ = conn.WriteMessage(websocket.OpText, payload)
+	}))
+	defer server.Close()
+
+	responses, err := NewQueryClient(server.URL).Tail(context.Background(), `{app="a"}`, 0, time.Time{})
+	if err != nil {
+		t.Fatalf("Tail() error = %s", err)
+	}
+
+	var (
+		lines   int
+		lastErr error
+		timeout = time.After(10 * time.Second)
+	)
+
+	for done := false; !done; {
+		select {
+		case response, ok := <-responses:
+			if !ok {
+				done = true
+				break
+			}
+			for _, stream := range response.Streams {
+				lines += len(stream.Entries)
+			}
+			if response.Err != nil {
+				lastErr = response.Err
+			}
+		case <-timeout:
+			t.Fatal("timed out, channel must be closed after a permanent reconnect error")
+		}
+	}
+
+	if lines != 1 {
+		t.Errorf("received lines = %d, want 1", lines)
+	}
+
+	responseErr, ok := lastErr.(*UnexpectedResponseError)
+	if !ok || responseErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("last response error = %v, want unexpected response error with 401 status", lastErr)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if counter != 2 {
+		t.Errorf("connections = %d, want 2, permanent error must not be retried", counter)
+	}
+}
@@ -0,0 +1,156 @@
+package promtail
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+//
+// TLS settings of connections to Loki, zero value stands for system defaults
+//
+type TLSConfig struct {
+	// PEM bundle of CAs to verify Loki's certificate with, instead of system ones
+	CAFile string
+	// Client certificate and key (PEM) for mTLS, reloaded once files are changed on disk
+	CertFile string
+	KeyFile  string
+	// Overrides the name Loki's certificate is verified against
+	ServerName string
+	// Disables verification of Loki's certificate, use in development only
+	InsecureSkipVerify bool
+	// Minimum TLS version, e.g. tls.VersionTLS12
+	MinVersion uint16
+}
+
+//
+// Implemented by exchangers sending requests over HTTP, so the transport could be configured
+//
+type HTTPExchanger interface {
+	SetHTTPClient(httpClient *http.Client)
+	SetTLSConfig(config TLSConfig) error
+}
+
+func (rcv *lokiRESTClient) SetHTTPClient(httpClient *http.Client) {
+	rcv.restClient = httpClient
+}
+
+//
+// Applies TLS settings to a copy of the current transport
+//	NOTE: custom round trippers (other than *http.Transport) can't be configured
+//
+func (rcv *lokiRESTClient) SetTLSConfig(config TLSConfig) error {
+	tlsConfig, err := config.build()
+	if err != nil {
+		return err
+	}
+
+	var transport *http.Transport
+
+	switch current := rcv.restClient.Transport.(type) {
+	case nil:
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	case *http.Transport:
+		transport = current.Clone()
+	default:
+		return errors.New("TLS can't be configured for a custom round tripper")
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	httpClient := *rcv.restClient
+	httpClient.Transport = transport
+	rcv.restClient = &httpClient
+
+	return nil
+}
+
+func (rcv TLSConfig) build() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         rcv.ServerName,
+		InsecureSkipVerify: rcv.InsecureSkipVerify,
+		MinVersion:         rcv.MinVersion,
+	}
+
+	if rcv.CAFile != "" {
+		caBundle, err := ioutil.ReadFile(rcv.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %s", err)
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caBundle) {
+			return nil, fmt.Errorf("no certificates are found in CA bundle: %s", rcv.CAFile)
+		}
+	}
+
+	if rcv.CertFile != "" || rcv.KeyFile != "" {
+		if rcv.CertFile == "" || rcv.KeyFile == "" {
+			return nil, errors.New("both client certificate and key files are required")
+		}
+
+		reloader := &certificateReloader{certFile: rcv.CertFile, keyFile: rcv.KeyFile}
+
+		// Fails fast on a broken key pair, instead of failing every push later
+		if _, err := reloader.getCertificate(); err != nil {
+			return nil, err
+		}
+
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return reloader.getCertificate()
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+//
+// Keeps a client certificate, which is reloaded on TLS handshake if its files are changed
+//	NOTE: the last loaded certificate is used while files are being replaced
+//
+type certificateReloader struct {
+	certFile string
+	keyFile  string
+
+	mu          sync.Mutex
+	certificate *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+}
+
+func (rcv *certificateReloader) getCertificate() (*tls.Certificate, error) {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	certInfo, certErr := os.Stat(rcv.certFile)
+	keyInfo, keyErr := os.Stat(rcv.keyFile)
+
+	if certErr != nil || keyErr != nil {
+		return rcv.cachedCertificate(fmt.Errorf("failed to check client certificate files: %v, %v", certErr, keyErr))
+	}
+
+	if rcv.certificate != nil && certInfo.ModTime().Equal(rcv.certModTime) && keyInfo.ModTime().Equal(rcv.keyModTime) {
+		return rcv.certificate, nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(rcv.certFile, rcv.keyFile)
+	if err != nil {
+		return rcv.cachedCertificate(fmt.Errorf("failed to load client certificate: %s", err))
+	}
+
+	rcv.certificate, rcv.certModTime, rcv.keyModTime = &certificate, certInfo.ModTime(), keyInfo.ModTime()
+
+	return rcv.certificate, nil
+}
+
+func (rcv *certificateReloader) cachedCertificate(err error) (*tls.Certificate, error) {
+	if rcv.certificate != nil {
+		return rcv.certificate, nil
+	}
+	return nil, err
+}
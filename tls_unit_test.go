@@ -0,0 +1,239 @@
+// +build unit
+
+package promtail
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testCertificate struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	certPEM     []byte
+	keyPEM      []byte
+}
+
+// Issues a certificate signed by the parent (self-signed CA if the parent is nil)
+func newTestCertificate(t *testing.T, commonName string, parent *testCertificate) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate key: %s", err)
+	}
+
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.certificate, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("unable to create certificate: %s", err)
+	}
+	certificate, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	return &testCertificate{
+		certificate: certificate,
+		key:         key,
+		certPEM:     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:      pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func writeTestFile(t *testing.T, path string, content []byte, modTime time.Time) {
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		t.Fatalf("unable to write file: %s", err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("unable to change file time: %s", err)
+	}
+}
+
+func TestTLSConfig_MutualTLS(t *testing.T) {
+	directory, err := ioutil.TempDir("", "promtail-tls")
+	if err != nil {
+		t.Fatalf("unable to create directory: %s", err)
+	}
+	defer os.RemoveAll(directory)
+
+	var (
+		ca           = newTestCertificate(t, "ca", nil)
+		server       = newTestCertificate(t, "loki", ca)
+		firstClient  = newTestCertificate(t, "client-1", ca)
+		secondClient = newTestCertificate(t, "client-2", ca)
+
+		caFile   = filepath.Join(directory, "ca.pem")
+		certFile = filepath.Join(directory, "client.pem")
+		keyFile  = filepath.Join(directory, "client-key.pem")
+
+		mu          sync.Mutex
+		clientNames []string
+	)
+
+	writeTestFile(t, caFile, ca.certPEM, time.Now())
+	writeTestFile(t, certFile, firstClient.certPEM, time.Now().Add(-time.Minute))
+	writeTestFile(t, keyFile, firstClient.keyPEM, time.Now().Add(-time.Minute))
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.certificate)
+
+	loki := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		clientNames = append(clientNames, r.TLS.PeerCertificates[0].Subject.CommonName)
+		mu.Unlock()
+
+		// New connection (and handshake) for every request
+		w.Header().Set("Connection", "close")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	loki.TLS = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{server.certificate.Raw}, PrivateKey: server.key}},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}
+	loki.StartTLS()
+	defer loki.Close()
+
+	exchanger := NewJSONv1Exchanger(loki.URL)
+
+	// System CAs don't trust the server
+	if _, err = exchanger.Ping(); err == nil {
+		t.Error("expected error on untrusted server, but not occurred")
+	}
+
+	err = exchanger.(HTTPExchanger).SetTLSConfig(TLSConfig{
+		CAFile:     caFile,
+		CertFile:   certFile,
+		KeyFile:    keyFile,
+		MinVersion: tls.VersionTLS12,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error on TLS configuration: %s", err)
+	}
+
+	if pong, err := exchanger.Ping(); err != nil || !pong.IsReady {
+		t.Fatalf("Ping() = %v, %v, want ready", pong, err)
+	}
+
+	// Rotated certificate is presented on the next handshake
+	writeTestFile(t, certFile, secondClient.certPEM, time.Now())
+	writeTestFile(t, keyFile, secondClient.keyPEM, time.Now())
+
+	if pong, err := exchanger.Ping(); err != nil || !pong.IsReady {
+		t.Fatalf("Ping() = %v, %v, want ready", pong, err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(clientNames) != 2 || clientNames[0] != "client-1" || clientNames[1] != "client-2" {
+		t.Errorf("unexpected client certificates: %v, want [client-1 client-2]", clientNames)
+	}
+}
+
+func TestTLSConfig_InsecureSkipVerify(t *testing.T) {
+	loki := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer loki.Close()
+
+	client, err := NewJSONv1Client(loki.URL, nil, WithTLSConfig(TLSConfig{InsecureSkipVerify: true}))
+	if err != nil {
+		t.Fatalf("unexpected error on client initialization: %s", err)
+	}
+	defer client.Close()
+
+	if pong, err := client.Ping(); err != nil || !pong.IsReady {
+		t.Errorf("Ping() = %v, %v, want ready", pong, err)
+	}
+}
+
+type countingRoundTripper struct {
+	mu       sync.Mutex
+	requests int
+}
+
+func (rcv *countingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	rcv.mu.Lock()
+	rcv.requests++
+	rcv.mu.Unlock()
+
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestWithRoundTripper(t *testing.T) {
+	loki := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer loki.Close()
+
+	roundTripper := &countingRoundTripper{}
+
+	client, err := NewJSONv1Client(loki.URL, nil, WithRoundTripper(roundTripper))
+	if err != nil {
+		t.Fatalf("unexpected error on client initialization: %s", err)
+	}
+	defer client.Close()
+
+	if _, err = client.Ping(); err != nil {
+		t.Errorf("unexpected ping error: %s", err)
+	}
+	if roundTripper.requests != 1 {
+		t.Errorf("custom round tripper is not used, requests: %d", roundTripper.requests)
+	}
+
+	// TLS of a custom round tripper is up to its owner
+	_, err = NewJSONv1Client(loki.URL, nil, WithRoundTripper(roundTripper), WithTLSConfig(TLSConfig{}))
+	if err == nil {
+		t.Error("expected error on TLS configuration of a custom round tripper, but not occurred")
+	}
+}
+
+func TestTLSConfig_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		config TLSConfig
+	}{
+		{name: "Missing CA bundle", config: TLSConfig{CAFile: "/nonexistent/ca.pem"}},
+		{name: "Certificate without key", config: TLSConfig{CertFile: "/nonexistent/client.pem"}},
+		{name: "Missing key pair", config: TLSConfig{CertFile: "/nonexistent/client.pem", KeyFile: "/nonexistent/key.pem"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewJSONv1Client("loki", nil, WithTLSConfig(tt.config)); err == nil {
+				t.Error("expected error on invalid TLS configuration, but not occurred")
+			}
+		})
+	}
+}
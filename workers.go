@@ -0,0 +1,96 @@
+package promtail
+
+import (
+	"hash/fnv"
+	"sync"
+
+	"github.com/ic2hrmk/promtail/internal/logproto"
+)
+
+//
+// Pool of goroutines pushing sealed batches, so a slow push doesn't stop batching
+//	NOTE: a stream always goes to the same worker, which keeps its entries ordered
+//
+type pushWorkers struct {
+	batches  []chan []*LogStream
+	inFlight sync.WaitGroup
+	stopped  sync.WaitGroup
+}
+
+func newPushWorkers(concurrency uint, push func(streams []*LogStream)) *pushWorkers {
+	rcv := &pushWorkers{
+		batches: make([]chan []*LogStream, concurrency),
+	}
+
+	for i := range rcv.batches {
+		// A single pending batch per worker, then batching waits for a free one
+		rcv.batches[i] = make(chan []*LogStream, 1)
+
+		rcv.stopped.Add(1)
+		go rcv.work(rcv.batches[i], push)
+	}
+
+	return rcv
+}
+
+func (rcv *pushWorkers) work(batches <-chan []*LogStream, push func(streams []*LogStream)) {
+	defer rcv.stopped.Done()
+
+	for streams := range batches {
+		push(streams)
+		rcv.inFlight.Done()
+	}
+}
+
+//
+// Splits streams between workers by their labels and tenant
+//	NOTE: blocks while the chosen worker already has a pending batch
+//
+func (rcv *pushWorkers) dispatch(streams []*LogStream) {
+	shards := make([][]*LogStream, len(rcv.batches))
+
+	for i := range streams {
+		if streams[i] == nil || len(streams[i].Entries) == 0 {
+			continue
+		}
+
+		shard := rcv.shardOf(streams[i])
+		shards[shard] = append(shards[shard], streams[i])
+	}
+
+	for i := range shards {
+		if len(shards[i]) == 0 {
+			continue
+		}
+
+		rcv.inFlight.Add(1)
+		rcv.batches[i] <- shards[i]
+	}
+}
+
+func (rcv *pushWorkers) shardOf(stream *LogStream) int {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(stream.TenantID))
+	_, _ = hash.Write([]byte{0})
+	_, _ = hash.Write([]byte(logproto.FormatLabels(stream.Labels)))
+
+	return int(hash.Sum32() % uint32(len(rcv.batches)))
+}
+
+//
+// Waits until every dispatched batch is pushed
+//
+func (rcv *pushWorkers) await() {
+	rcv.inFlight.Wait()
+}
+
+//
+// Waits for dispatched batches and stops workers, no batches are accepted afterwards
+//
+func (rcv *pushWorkers) stop() {
+	for i := range rcv.batches {
+		close(rcv.batches[i])
+	}
+
+	rcv.stopped.Wait()
+}
@@ -0,0 +1,77 @@
+package promtail
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"sync"
+)
+
+// Partial lines longer than that are sent without waiting for a line break
+const maxWriterLineSize = 64 * 1024
+
+//
+// Returns a writer which splits its input into lines and logs each of them
+// with a given level and labels. Incomplete lines are kept until a line break comes.
+//
+func (rcv *promtailClient) Writer(level Level, labels map[string]string) io.Writer {
+	return &lineWriter{
+		client: rcv,
+		level:  level,
+		labels: copyLabels(labels),
+	}
+}
+
+//
+// Returns a standard library logger which logs every message with a given level and labels,
+// e.g. to be used as http.Server.ErrorLog
+//
+func (rcv *promtailClient) StdLogger(level Level, labels map[string]string) *log.Logger {
+	return log.New(rcv.Writer(level, labels), "", 0)
+}
+
+type lineWriter struct {
+	client *promtailClient
+	level  Level
+	labels map[string]string
+
+	mu     sync.Mutex
+	buffer []byte
+}
+
+func (rcv *lineWriter) Write(p []byte) (int, error) {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+
+	rcv.buffer = append(rcv.buffer, p...)
+
+	consumed := 0
+	for {
+		lineEnd := bytes.IndexByte(rcv.buffer[consumed:], '\n')
+		if lineEnd < 0 {
+			break
+		}
+
+		rcv.logLine(rcv.buffer[consumed : consumed+lineEnd])
+		consumed += lineEnd + 1
+	}
+
+	if len(rcv.buffer)-consumed >= maxWriterLineSize {
+		rcv.logLine(rcv.buffer[consumed:])
+		consumed = len(rcv.buffer)
+	}
+
+	rcv.buffer = append(rcv.buffer[:0], rcv.buffer[consumed:]...)
+
+	return len(p), nil
+}
+
+func (rcv *lineWriter) logLine(line []byte) {
+	line = bytes.TrimSuffix(line, []byte{'\r'})
+	if len(line) == 0 {
+		return
+	}
+
+	// Lines of writers carry no attributes, so every line is a template of its own
+	rcv.client.LogWithTemplate(rcv.level, rcv.labels, string(line), string(line))
+}
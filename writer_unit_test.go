@@ -0,0 +1,90 @@
+// +build unit
+
+package promtail
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func pushedLines(exchanger *fakeExchanger) []string {
+	exchanger.mu.Lock()
+	defer exchanger.mu.Unlock()
+
+	var lines []string
+	for i := range exchanger.pushed {
+		for j := range exchanger.pushed[i] {
+			for _, entry := range exchanger.pushed[i][j].Entries {
+				lines = append(lines, fmt.Sprintf(entry.Format, entry.Args...))
+			}
+		}
+	}
+	return lines
+}
+
+func TestPromtailClient_Writer(t *testing.T) {
+	exchanger := &fakeExchanger{}
+
+	client, err := NewClient(exchanger, nil, WithSendBatchSize(100))
+	if err != nil {
+		t.Fatalf("unexpected error on client initialization: %s", err)
+	}
+
+	writer := client.Writer(Warn, map[string]string{"component": "http"})
+
+	for _, chunk := range []string{
+		"first line\nsecond ",
+		"line\r\n\n",
+		"100% done\n",
+		"incomplete",
+	} {
+		if n, err := writer.Write([]byte(chunk)); n != len(chunk) || err != nil {
+			t.Fatalf("unexpected write result, n: %d, err: %v", n, err)
+		}
+	}
+
+	_, _ = writer.Write([]byte(strings.Repeat("x", maxWriterLineSize)))
+
+	client.Close()
+
+	want := []string{
+		"first line",
+		"second line",
+		"100% done",
+		"incomplete" + strings.Repeat("x", maxWriterLineSize),
+	}
+	if got := pushedLines(exchanger); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected lines:\n got  = %.100q\n want = %.100q", got, want)
+	}
+
+	for _, stream := range exchanger.pushed[0] {
+		if len(stream.Entries) > 0 && (stream.Level != Warn || stream.Labels["component"] != "http") {
+			t.Errorf("unexpected stream level or labels: %s, %v", stream.Level, stream.Labels)
+		}
+	}
+}
+
+func TestPromtailClient_StdLogger(t *testing.T) {
+	exchanger := &fakeExchanger{}
+
+	client, err := NewClient(exchanger, nil)
+	if err != nil {
+		t.Fatalf("unexpected error on client initialization: %s", err)
+	}
+
+	logger := client.StdLogger(Error, nil)
+	logger.Printf("http: TLS handshake error from %s", "10.0.0.1:5555")
+	logger.Print("no line break at the end")
+
+	client.Close()
+
+	want := []string{
+		"http: TLS handshake error from 10.0.0.1:5555",
+		"no line break at the end",
+	}
+	if got := pushedLines(exchanger); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected lines:\n got  = %q\n want = %q", got, want)
+	}
+}